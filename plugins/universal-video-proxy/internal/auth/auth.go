@@ -0,0 +1,76 @@
+// Package auth implements pluggable client authentication in front of the
+// proxy, independent of the per-URL HMAC signing done by internal/signer.
+// Authenticators are selected at startup from a scheme-string spec, e.g.
+// "basic:file:///etc/proxy/htpasswd", "bearer:env:TOKEN",
+// "bearer:jwks:https://issuer.example/.well-known/jwks.json",
+// "cert:file:///etc/proxy/clients.pem" or "static:user:pass".
+package auth
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Authenticator validates an inbound request and returns a non-nil error
+// describing why it was rejected.
+type Authenticator interface {
+	Authenticate(r *http.Request) error
+}
+
+// NewAuth parses a scheme-string spec and builds the matching Authenticator.
+// The scheme is everything before the first ':'; the rest is scheme-specific.
+func NewAuth(spec string) (Authenticator, error) {
+	scheme, rest, ok := strings.Cut(spec, ":")
+	if !ok {
+		return nil, fmt.Errorf("auth: spec %q missing scheme", spec)
+	}
+
+	switch scheme {
+	case "basic":
+		path, err := sourcePath(rest)
+		if err != nil {
+			return nil, fmt.Errorf("auth: basic: %w", err)
+		}
+		return NewBasicAuth(path)
+	case "static":
+		user, pass, ok := strings.Cut(rest, ":")
+		if !ok {
+			return nil, fmt.Errorf("auth: static spec must be \"static:user:pass\"")
+		}
+		return NewStaticAuth(user, pass), nil
+	case "bearer":
+		return newBearerAuth(rest)
+	case "cert":
+		path, err := sourcePath(rest)
+		if err != nil {
+			return nil, fmt.Errorf("auth: cert: %w", err)
+		}
+		return NewCertAuth(path)
+	default:
+		return nil, fmt.Errorf("auth: unknown scheme %q", scheme)
+	}
+}
+
+// sourcePath resolves a "file://..." source into the filesystem path the
+// basic/cert handlers load. Any other source, "env://..." included, is
+// rejected.
+func sourcePath(rest string) (string, error) {
+	switch {
+	case strings.HasPrefix(rest, "file://"):
+		return strings.TrimPrefix(rest, "file://"), nil
+	default:
+		return "", fmt.Errorf("unsupported source %q, expected file://", rest)
+	}
+}
+
+// Combine describes how a client-facing Authenticator composes with the
+// existing HMAC URL signer.
+type Combine string
+
+const (
+	// CombineEither accepts the request if either auth or the signer passes.
+	CombineEither Combine = "either"
+	// CombineBoth requires both auth and the signer to pass.
+	CombineBoth Combine = "both"
+)
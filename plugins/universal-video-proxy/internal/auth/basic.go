@@ -0,0 +1,103 @@
+package auth
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// BasicAuth validates HTTP Basic credentials against an htpasswd-style file:
+// one "user:hash" pair per line, "#"-prefixed lines and blank lines ignored.
+// Bcrypt hashes (the "$2y$"/"$2a$"/"$2b$" prefixes) are verified with
+// bcrypt.CompareHashAndPassword; anything else is treated as a plaintext
+// password and compared in constant time.
+type BasicAuth struct {
+	creds map[string]string
+}
+
+// NewBasicAuth loads an htpasswd file from path.
+func NewBasicAuth(path string) (*BasicAuth, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open htpasswd file: %w", err)
+	}
+	defer f.Close()
+
+	creds := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		user, hash, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		creds[user] = hash
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read htpasswd file: %w", err)
+	}
+
+	return &BasicAuth{creds: creds}, nil
+}
+
+func (a *BasicAuth) Authenticate(r *http.Request) error {
+	user, pass, ok := r.BasicAuth()
+	if !ok {
+		return fmt.Errorf("missing basic auth credentials")
+	}
+
+	hash, found := a.creds[user]
+	if !found {
+		return fmt.Errorf("unknown user")
+	}
+
+	if isBcryptHash(hash) {
+		if err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(pass)); err != nil {
+			return fmt.Errorf("password mismatch")
+		}
+		return nil
+	}
+
+	if subtle.ConstantTimeCompare([]byte(hash), []byte(pass)) != 1 {
+		return fmt.Errorf("password mismatch")
+	}
+	return nil
+}
+
+func isBcryptHash(hash string) bool {
+	return strings.HasPrefix(hash, "$2a$") || strings.HasPrefix(hash, "$2b$") || strings.HasPrefix(hash, "$2y$")
+}
+
+// StaticAuth checks HTTP Basic credentials against a single fixed user/pass
+// pair, for simple single-tenant deployments that don't want an htpasswd file.
+type StaticAuth struct {
+	user string
+	pass string
+}
+
+// NewStaticAuth returns a StaticAuth for the given credential pair.
+func NewStaticAuth(user, pass string) *StaticAuth {
+	return &StaticAuth{user: user, pass: pass}
+}
+
+func (a *StaticAuth) Authenticate(r *http.Request) error {
+	user, pass, ok := r.BasicAuth()
+	if !ok {
+		return fmt.Errorf("missing basic auth credentials")
+	}
+
+	userOK := subtle.ConstantTimeCompare([]byte(user), []byte(a.user)) == 1
+	passOK := subtle.ConstantTimeCompare([]byte(pass), []byte(a.pass)) == 1
+	if !userOK || !passOK {
+		return fmt.Errorf("credential mismatch")
+	}
+	return nil
+}
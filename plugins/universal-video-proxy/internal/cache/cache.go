@@ -3,6 +3,7 @@ package cache
 import (
 	"sync"
 	"time"
+
 	lru "github.com/hashicorp/golang-lru/v2"
 )
 
@@ -12,22 +13,27 @@ type CacheEntry struct {
 }
 
 type Cache struct {
-	lru     *lru.Cache[string, *CacheEntry]
-	ttl     time.Duration
-	enabled bool
-	mu      sync.RWMutex
+	lru      *lru.Cache[string, *CacheEntry]
+	negative *lru.Cache[string, time.Time]
+	ttl      time.Duration
+	negTTL   time.Duration
+	enabled  bool
+	mu       sync.RWMutex
 }
 
-func New(maxEntries int, ttlSeconds int, enabled bool) *Cache {
+func New(maxEntries int, ttlSeconds int, enabled bool, negativeTTLSeconds int) *Cache {
 	if !enabled || maxEntries <= 0 {
 		return &Cache{enabled: false}
 	}
 
 	cache, _ := lru.New[string, *CacheEntry](maxEntries)
+	negative, _ := lru.New[string, time.Time](maxEntries)
 	return &Cache{
-		lru:     cache,
-		ttl:     time.Duration(ttlSeconds) * time.Second,
-		enabled: enabled,
+		lru:      cache,
+		negative: negative,
+		ttl:      time.Duration(ttlSeconds) * time.Second,
+		negTTL:   time.Duration(negativeTTLSeconds) * time.Second,
+		enabled:  enabled,
 	}
 }
 
@@ -50,21 +56,79 @@ func (c *Cache) Get(key string) ([]byte, bool) {
 	return entry.Data, true
 }
 
+// Set caches data under key for the cache's configured TTL.
 func (c *Cache) Set(key string, data []byte) {
+	c.SetWithTTL(key, data, c.ttl)
+}
+
+// SetWithTTL caches data under key for ttl, so callers can honor an upstream
+// Cache-Control/Expires override instead of the static configured TTL. A
+// zero or negative ttl falls back to the configured TTL.
+func (c *Cache) SetWithTTL(key string, data []byte, ttl time.Duration) {
 	if !c.enabled {
 		return
 	}
+	if ttl <= 0 {
+		ttl = c.ttl
+	}
 
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	entry := &CacheEntry{
+	c.lru.Add(key, &CacheEntry{
 		Data:      data,
-		ExpiresAt: time.Now().Add(c.ttl),
-	}
-	c.lru.Add(key, entry)
+		ExpiresAt: time.Now().Add(ttl),
+	})
 }
 
 func (c *Cache) IsEnabled() bool {
 	return c.enabled
-}
\ No newline at end of file
+}
+
+// Delete removes key immediately, for callers that track a cached entry's
+// validity themselves - e.g. the live-playlist prefetcher evicting segments
+// that have slid out of the playlist's window before their TTL expires.
+func (c *Cache) Delete(key string) {
+	if !c.enabled {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.lru.Remove(key)
+}
+
+// IsNegative reports whether key was recently marked as a failing upstream
+// fetch, so callers can fail fast instead of hitting the origin again.
+func (c *Cache) IsNegative(key string) bool {
+	if !c.enabled || c.negTTL <= 0 {
+		return false
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	expiresAt, found := c.negative.Get(key)
+	if !found {
+		return false
+	}
+	if time.Now().After(expiresAt) {
+		c.negative.Remove(key)
+		return false
+	}
+	return true
+}
+
+// SetNegative marks key as failing for the cache's negative TTL, to protect
+// the origin during an outage.
+func (c *Cache) SetNegative(key string) {
+	if !c.enabled || c.negTTL <= 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.negative.Add(key, time.Now().Add(c.negTTL))
+}
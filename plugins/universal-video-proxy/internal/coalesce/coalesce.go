@@ -0,0 +1,143 @@
+// Package coalesce lets concurrent requests for the same key share a single
+// producer's bytes as they arrive, instead of each issuing its own upstream
+// fetch. It is the streaming counterpart to golang.org/x/sync/singleflight,
+// which only coalesces a call that returns one final result.
+package coalesce
+
+import (
+	"io"
+	"net/http"
+	"sync"
+)
+
+// Share fans the bytes written by one producer out to any number of
+// concurrent readers, each catching up from the start of the buffer and
+// then blocking for new data until Finish or Fail is called.
+type Share struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	status int
+	header http.Header
+	buf    []byte
+	done   bool
+	err    error
+}
+
+func newShare() *Share {
+	s := &Share{}
+	s.cond = sync.NewCond(&s.mu)
+	return s
+}
+
+// SetHeader records the response status/header the producer received, and
+// wakes any readers blocked in WaitHeader.
+func (s *Share) SetHeader(status int, header http.Header) {
+	s.mu.Lock()
+	s.status = status
+	s.header = header
+	s.cond.Broadcast()
+	s.mu.Unlock()
+}
+
+// WaitHeader blocks until the producer calls SetHeader or the share fails
+// before any header was set.
+func (s *Share) WaitHeader() (int, http.Header, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for s.status == 0 && s.err == nil {
+		s.cond.Wait()
+	}
+	return s.status, s.header, s.err
+}
+
+// Write implements io.Writer so the producer can copy straight into the
+// share alongside its own client's response writer.
+func (s *Share) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	s.buf = append(s.buf, p...)
+	s.cond.Broadcast()
+	s.mu.Unlock()
+	return len(p), nil
+}
+
+// Finish marks the share complete after a successful fetch.
+func (s *Share) Finish() {
+	s.mu.Lock()
+	s.done = true
+	s.cond.Broadcast()
+	s.mu.Unlock()
+}
+
+// Fail marks the share complete with an error, which CopyTo and WaitHeader
+// (if no header was set yet) return to every waiting reader.
+func (s *Share) Fail(err error) {
+	s.mu.Lock()
+	s.done = true
+	s.err = err
+	s.cond.Broadcast()
+	s.mu.Unlock()
+}
+
+// CopyTo streams the shared buffer to w from the start, blocking for new
+// data as it arrives, until the producer calls Finish or Fail. Named CopyTo
+// rather than WriteTo since its error-only signature doesn't satisfy
+// io.WriterTo (which returns (int64, error)) - keeping the name WriteTo
+// would fail go vet's stdmethods check.
+func (s *Share) CopyTo(w io.Writer) error {
+	offset := 0
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for {
+		if offset < len(s.buf) {
+			chunk := s.buf[offset:]
+			s.mu.Unlock()
+			n, err := w.Write(chunk)
+			s.mu.Lock()
+			if err != nil {
+				return err
+			}
+			offset += n
+			continue
+		}
+		if s.done {
+			return s.err
+		}
+		s.cond.Wait()
+	}
+}
+
+// Group tracks the in-flight Share for each key, so a second caller for the
+// same key joins the first's fetch instead of starting a new one.
+type Group struct {
+	mu     sync.Mutex
+	active map[string]*Share
+}
+
+// NewGroup returns an empty Group.
+func NewGroup() *Group {
+	return &Group{active: make(map[string]*Share)}
+}
+
+// Join returns the Share for key. The first caller for a given key becomes
+// the leader (isLeader true) and is responsible for producing the bytes and
+// calling Leave when done; later callers join as readers.
+func (g *Group) Join(key string) (share *Share, isLeader bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if s, found := g.active[key]; found {
+		return s, false
+	}
+
+	s := newShare()
+	g.active[key] = s
+	return s, true
+}
+
+// Leave removes key's Share once its fetch has finished, so the next
+// request for key starts a fresh fetch rather than replaying a stale one.
+func (g *Group) Leave(key string) {
+	g.mu.Lock()
+	delete(g.active, key)
+	g.mu.Unlock()
+}
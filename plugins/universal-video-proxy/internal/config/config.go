@@ -15,6 +15,61 @@ type Config struct {
 	Cache      CacheConfig  `json:"cache"`
 	Headers    []HeaderRule `json:"headers"`
 	Upstream   UpstreamConfig `json:"upstream"`
+	Auth       AuthConfig   `json:"auth"`
+	Prefetch   PrefetchConfig `json:"prefetch"`
+	DRM        DRMConfig    `json:"drm"`
+}
+
+// DRMConfig configures session-scoped AES-128 key re-encryption in
+// internal/drm: segments are decrypted with the upstream key and
+// re-encrypted with a proxy-generated key bound to the client's signed
+// session, so /key never serves the origin's real key.
+type DRMConfig struct {
+	// Enabled turns on re-encryption. When false, /key and /seg behave
+	// exactly as before (plain passthrough).
+	Enabled bool `json:"enabled"`
+	// SessionTTLSeconds controls how long a session's re-encryption key
+	// stays valid; defaults to 600 if unset.
+	SessionTTLSeconds int `json:"sessionTTLSeconds"`
+	// MaxSegmentBytes caps how large a segment re-encryption will buffer in
+	// memory to decrypt/re-encrypt it. Independent of Limits.MaxCacheableBytes,
+	// which sizes "is this worth caching" rather than "can this be DRM
+	// re-encrypted" - real TS/CMAF segments routinely exceed MaxCacheableBytes.
+	// Defaults to 16MiB if unset.
+	MaxSegmentBytes int `json:"maxSegmentBytes"`
+}
+
+// PrefetchConfig configures the live-playlist segment prefetcher in
+// internal/prefetch.
+type PrefetchConfig struct {
+	// Enabled turns on eager prefetching of newly-appeared segment/map URIs
+	// for live (non-ENDLIST) media playlists served by /play.
+	Enabled bool `json:"enabled"`
+	// MaxConcurrentPerPlaylist caps in-flight prefetch fetches per playlist.
+	MaxConcurrentPerPlaylist int `json:"maxConcurrentPerPlaylist"`
+}
+
+// AuthConfig configures the pluggable client-authentication layer in
+// internal/auth, sitting in front of the existing per-URL HMAC Sign config.
+type AuthConfig struct {
+	// Enabled turns on the authenticator built from Spec.
+	Enabled bool `json:"enabled"`
+	// Spec is a scheme string consumed by auth.NewAuth, e.g.
+	// "basic:file:///etc/proxy/htpasswd", "bearer:env:TOKEN",
+	// "bearer:jwks:https://issuer/.well-known/jwks.json",
+	// "cert:file:///etc/proxy/clients.pem" or "static:user:pass".
+	Spec string `json:"spec"`
+	// AllowNames restricts a "cert" authenticator to these CN/SAN values.
+	AllowNames []string `json:"allowNames"`
+	// Combine controls how Auth composes with Sign: "either" accepts the
+	// request if either one passes, "both" (the default) requires both.
+	Combine string `json:"combine"`
+	// TLSCertFile and TLSKeyFile are the server's own certificate and key,
+	// required when Spec is a "cert:" authenticator: client-certificate
+	// verification only happens during the TLS handshake, so a "cert" auth
+	// mode forces the listener itself to serve TLS instead of plain HTTP.
+	TLSCertFile string `json:"tlsCertFile"`
+	TLSKeyFile  string `json:"tlsKeyFile"`
 }
 
 type CORSConfig struct {
@@ -28,13 +83,35 @@ type SignConfig struct {
 }
 
 type LimitsConfig struct {
-	MaxPlaylistKB int `json:"maxPlaylistKB"`
-	MaxURLLength  int `json:"maxURLLength"`
+	MaxPlaylistKB     int `json:"maxPlaylistKB"`
+	MaxURLLength      int `json:"maxURLLength"`
+	MaxCacheableBytes int `json:"maxCacheableBytes"`
+
+	// RateLimitEnabled enables per-visitor throttling via internal/limiter.
+	RateLimitEnabled bool `json:"rateLimitEnabled"`
+	// RequestsPerSecond and Burst size each visitor's request-rate limiter.
+	RequestsPerSecond float64 `json:"requestsPerSecond"`
+	Burst             int     `json:"burst"`
+	// MaxInFlightPerVisitor caps concurrent in-progress requests per visitor.
+	MaxInFlightPerVisitor int `json:"maxInFlightPerVisitor"`
+	// MaxBytesPerSecondPerIP caps each visitor's outbound response byte rate; 0 disables it.
+	MaxBytesPerSecondPerIP int `json:"maxBytesPerSecondPerIP"`
+	// MaxConcurrentUpstream caps in-progress requests across all visitors; 0 disables it.
+	// Every admitted request holds a slot until it finishes, whether it is
+	// served from cache or fetched upstream.
+	MaxConcurrentUpstream int `json:"maxConcurrentUpstream"`
+	// TrustedProxies lists CIDRs allowed to set X-Forwarded-For when resolving a visitor's IP.
+	TrustedProxies []string `json:"trustedProxies"`
+	// VisitorIdleSeconds and VisitorSweepSeconds control eviction of stale visitor entries.
+	VisitorIdleSeconds  int `json:"visitorIdleSeconds"`
+	VisitorSweepSeconds int `json:"visitorSweepSeconds"`
 }
 
 type RewriteConfig struct {
-	EnableM3U8      bool `json:"enableM3U8"`
-	KeepAllVariants bool `json:"keepAllVariants"`
+	EnableM3U8      bool   `json:"enableM3U8"`
+	KeepAllVariants bool   `json:"keepAllVariants"`
+	VariantSelect   string `json:"variantSelect"`
+	TargetBitrate   int    `json:"targetBitrate"`
 }
 
 type CacheConfig struct {
@@ -44,9 +121,10 @@ type CacheConfig struct {
 }
 
 type CacheEntry struct {
-	Enabled    bool `json:"enabled"`
-	TTLSeconds int  `json:"ttlSeconds"`
-	MaxEntries int  `json:"maxEntries"`
+	Enabled            bool `json:"enabled"`
+	TTLSeconds         int  `json:"ttlSeconds"`
+	MaxEntries         int  `json:"maxEntries"`
+	NegativeTTLSeconds int  `json:"negativeTtlSeconds"`
 }
 
 type HeaderRule struct {
@@ -54,14 +132,44 @@ type HeaderRule struct {
 	UseRegex    bool              `json:"useRegex"`
 	Set         map[string]string `json:"set"`
 	HostRewrite bool              `json:"hostRewrite"`
+
+	// StripRequestHeaders removes these headers (case-insensitive) from the
+	// incoming client request before it's forwarded upstream - e.g.
+	// "Authorization", "Cookie", "Etag" - for hosts where passing the
+	// client's own headers through verbatim would leak them or confuse the
+	// origin.
+	StripRequestHeaders []string `json:"stripRequestHeaders"`
+	// ResponseSet overrides response headers returned to the client, e.g.
+	// forcing a Cache-Control the origin didn't send.
+	ResponseSet map[string]string `json:"responseSet"`
+	// StripResponseHeaders removes these headers (case-insensitive) from the
+	// upstream response before it reaches the client, e.g. "Set-Cookie".
+	StripResponseHeaders []string `json:"stripResponseHeaders"`
+	// CookieJar replays Set-Cookie from a prior response to this host (e.g.
+	// a signed-URL bootstrap fetch) as a Cookie header on later requests to
+	// the same host, such as the segment/key fetches that follow it.
+	CookieJar bool `json:"cookieJar"`
 }
 
 type UpstreamConfig struct {
-	TimeoutMs       int    `json:"timeoutMs"`
-	FollowRedirects bool   `json:"followRedirects"`
-	MaxRedirects    int    `json:"maxRedirects"`
-	HTTPProxy       string `json:"httpProxy"`
-	Socks5          string `json:"socks5"`
+	TimeoutMs       int       `json:"timeoutMs"`
+	FollowRedirects bool      `json:"followRedirects"`
+	MaxRedirects    int       `json:"maxRedirects"`
+	HTTPProxy       string    `json:"httpProxy"`
+	Socks5          string    `json:"socks5"`
+	TLS             TLSConfig `json:"tls"`
+	// Protocols maps an upstream hostname (matched the same way as
+	// AllowHosts: exact or suffix match) to the transport to use for it,
+	// "h2" or "h3". The "*" entry is the default for hosts with no specific
+	// entry. A QUIC ("h3") fetch that fails falls back to H2 automatically.
+	Protocols map[string]string `json:"protocols"`
+}
+
+type TLSConfig struct {
+	InsecureSkipVerify bool     `json:"insecureSkipVerify"`
+	MinVersion         string   `json:"minVersion"`
+	MaxVersion         string   `json:"maxVersion"`
+	CipherSuites       []string `json:"cipherSuites"`
 }
 
 func LoadConfig(filename string) (*Config, error) {
@@ -85,6 +193,35 @@ func LoadConfig(filename string) (*Config, error) {
 	if cfg.Limits.MaxURLLength == 0 {
 		cfg.Limits.MaxURLLength = 2048
 	}
+	if cfg.Limits.MaxCacheableBytes == 0 {
+		cfg.Limits.MaxCacheableBytes = 1024 * 1024
+	}
+	if cfg.Limits.RateLimitEnabled {
+		if cfg.Limits.RequestsPerSecond == 0 {
+			cfg.Limits.RequestsPerSecond = 5
+		}
+		if cfg.Limits.Burst == 0 {
+			cfg.Limits.Burst = 10
+		}
+		if cfg.Limits.MaxInFlightPerVisitor == 0 {
+			cfg.Limits.MaxInFlightPerVisitor = 4
+		}
+		if cfg.Limits.VisitorIdleSeconds == 0 {
+			cfg.Limits.VisitorIdleSeconds = 300
+		}
+		if cfg.Limits.VisitorSweepSeconds == 0 {
+			cfg.Limits.VisitorSweepSeconds = 60
+		}
+	}
+	if cfg.Prefetch.Enabled && cfg.Prefetch.MaxConcurrentPerPlaylist == 0 {
+		cfg.Prefetch.MaxConcurrentPerPlaylist = 2
+	}
+	if cfg.DRM.Enabled && cfg.DRM.SessionTTLSeconds == 0 {
+		cfg.DRM.SessionTTLSeconds = 600
+	}
+	if cfg.DRM.Enabled && cfg.DRM.MaxSegmentBytes == 0 {
+		cfg.DRM.MaxSegmentBytes = 16 * 1024 * 1024
+	}
 	if cfg.Upstream.TimeoutMs == 0 {
 		cfg.Upstream.TimeoutMs = 15000
 	}
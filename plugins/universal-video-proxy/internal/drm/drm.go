@@ -0,0 +1,139 @@
+// Package drm re-encrypts AES-128 HLS segments at the proxy edge: instead of
+// handing a client the real upstream key, the proxy decrypts each segment
+// with the upstream key and re-encrypts it with a key generated for that
+// client's signed session, so /key never leaks the origin's key.
+package drm
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+const keySize = 16 // AES-128
+
+// Session is a proxy-generated AES-128 key bound to one signed client
+// session, re-encrypting segments that were decrypted with upstreamKeyURL's
+// real key.
+type Session struct {
+	Key            [keySize]byte
+	UpstreamKeyURL string
+	expiresAt      time.Time
+}
+
+// Manager issues and looks up per-session re-encryption keys, keyed by a
+// session ID the caller derives from the signed request (e.g. ts+sign), so a
+// session's key is only ever reachable by the client that owns that
+// signature.
+type Manager struct {
+	ttl time.Duration
+
+	mu       sync.Mutex
+	sessions map[string]*Session
+}
+
+// NewManager creates a Manager whose sessions expire after ttlSeconds
+// (defaulting to 10 minutes if unset).
+func NewManager(ttlSeconds int) *Manager {
+	ttl := time.Duration(ttlSeconds) * time.Second
+	if ttl <= 0 {
+		ttl = 10 * time.Minute
+	}
+	return &Manager{ttl: ttl, sessions: make(map[string]*Session)}
+}
+
+// SessionFor returns the session keyed by sessionID, generating a fresh
+// random AES-128 key bound to upstreamKeyURL the first time it's requested.
+func (m *Manager) SessionFor(sessionID, upstreamKeyURL string) (*Session, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if s, ok := m.sessions[sessionID]; ok && time.Now().Before(s.expiresAt) {
+		return s, nil
+	}
+
+	var key [keySize]byte
+	if _, err := rand.Read(key[:]); err != nil {
+		return nil, fmt.Errorf("generate session key: %w", err)
+	}
+	s := &Session{Key: key, UpstreamKeyURL: upstreamKeyURL, expiresAt: time.Now().Add(m.ttl)}
+	m.sessions[sessionID] = s
+	return s, nil
+}
+
+// Decrypt reverses AES-128-CBC with PKCS7 padding, as used by HLS segment
+// encryption (RFC 8216 section 5.2, METHOD=AES-128).
+func Decrypt(ciphertext, key, iv []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(ciphertext) == 0 || len(ciphertext)%aes.BlockSize != 0 {
+		return nil, fmt.Errorf("ciphertext length %d is not a multiple of the block size", len(ciphertext))
+	}
+
+	plaintext := make([]byte, len(ciphertext))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(plaintext, ciphertext)
+	return unpad(plaintext)
+}
+
+// Encrypt applies AES-128-CBC with PKCS7 padding, as used by HLS segment
+// encryption.
+func Encrypt(plaintext, key, iv []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	padded := pad(plaintext, aes.BlockSize)
+	ciphertext := make([]byte, len(padded))
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(ciphertext, padded)
+	return ciphertext, nil
+}
+
+func pad(data []byte, blockSize int) []byte {
+	padLen := blockSize - len(data)%blockSize
+	padded := make([]byte, len(data)+padLen)
+	copy(padded, data)
+	for i := len(data); i < len(padded); i++ {
+		padded[i] = byte(padLen)
+	}
+	return padded
+}
+
+func unpad(data []byte) ([]byte, error) {
+	padLen := int(data[len(data)-1])
+	if padLen == 0 || padLen > len(data) {
+		return nil, fmt.Errorf("invalid PKCS7 padding")
+	}
+	return data[:len(data)-padLen], nil
+}
+
+// ResolveIV returns the 16-byte IV an EXT-X-KEY tag implies for a segment:
+// ivAttr decoded if the tag carried an explicit IV attribute (a "0x"-prefixed
+// hex string), or the segment's media sequence number per the HLS default
+// when ivAttr is empty.
+func ResolveIV(ivAttr string, sequenceNumber uint64) ([]byte, error) {
+	if ivAttr == "" {
+		iv := make([]byte, keySize)
+		for i := 0; i < 8; i++ {
+			iv[keySize-1-i] = byte(sequenceNumber >> uint(8*i))
+		}
+		return iv, nil
+	}
+
+	trimmed := strings.TrimPrefix(strings.TrimPrefix(ivAttr, "0x"), "0X")
+	iv, err := hex.DecodeString(trimmed)
+	if err != nil {
+		return nil, fmt.Errorf("decode IV: %w", err)
+	}
+	if len(iv) != keySize {
+		return nil, fmt.Errorf("IV must be %d bytes, got %d", keySize, len(iv))
+	}
+	return iv, nil
+}
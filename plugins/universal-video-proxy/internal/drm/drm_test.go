@@ -0,0 +1,101 @@
+package drm
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestEncryptDecryptRoundTrip(t *testing.T) {
+	key := bytes.Repeat([]byte{0x42}, keySize)
+	iv := bytes.Repeat([]byte{0x24}, keySize)
+	plaintext := []byte("this is a fake TS segment payload, not block-aligned")
+
+	ciphertext, err := Encrypt(plaintext, key, iv)
+	if err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+	if len(ciphertext)%16 != 0 {
+		t.Fatalf("ciphertext length %d is not block-aligned", len(ciphertext))
+	}
+
+	decrypted, err := Decrypt(ciphertext, key, iv)
+	if err != nil {
+		t.Fatalf("Decrypt: %v", err)
+	}
+	if !bytes.Equal(decrypted, plaintext) {
+		t.Errorf("round trip mismatch: got %q, want %q", decrypted, plaintext)
+	}
+}
+
+// TestReencryptRoundTrip mirrors what streamReencryptedSegment does: decrypt
+// with the upstream key, then re-encrypt with the session's proxy-generated
+// key, and confirm the result decrypts back to the original plaintext under
+// the session key rather than the upstream one.
+func TestReencryptRoundTrip(t *testing.T) {
+	upstreamKey := bytes.Repeat([]byte{0x11}, keySize)
+	upstreamIV := bytes.Repeat([]byte{0x22}, keySize)
+	plaintext := []byte("segment bytes that came from the real origin key")
+
+	upstreamCiphertext, err := Encrypt(plaintext, upstreamKey, upstreamIV)
+	if err != nil {
+		t.Fatalf("Encrypt (upstream): %v", err)
+	}
+
+	mgr := NewManager(0)
+	session, err := mgr.SessionFor("session-1", "https://origin.example/key")
+	if err != nil {
+		t.Fatalf("SessionFor: %v", err)
+	}
+
+	decrypted, err := Decrypt(upstreamCiphertext, upstreamKey, upstreamIV)
+	if err != nil {
+		t.Fatalf("Decrypt (upstream): %v", err)
+	}
+	if !bytes.Equal(decrypted, plaintext) {
+		t.Fatalf("decrypted upstream segment mismatch: got %q, want %q", decrypted, plaintext)
+	}
+
+	sessionIV, err := ResolveIV("", 7)
+	if err != nil {
+		t.Fatalf("ResolveIV: %v", err)
+	}
+
+	reencrypted, err := Encrypt(decrypted, session.Key[:], sessionIV)
+	if err != nil {
+		t.Fatalf("Encrypt (session): %v", err)
+	}
+	if bytes.Equal(reencrypted, upstreamCiphertext) {
+		t.Errorf("re-encrypted segment should differ from the upstream ciphertext")
+	}
+
+	redecrypted, err := Decrypt(reencrypted, session.Key[:], sessionIV)
+	if err != nil {
+		t.Fatalf("Decrypt (session): %v", err)
+	}
+	if !bytes.Equal(redecrypted, plaintext) {
+		t.Errorf("session round trip mismatch: got %q, want %q", redecrypted, plaintext)
+	}
+}
+
+func TestResolveIVDefaultFromSequenceNumber(t *testing.T) {
+	iv, err := ResolveIV("", 1)
+	if err != nil {
+		t.Fatalf("ResolveIV: %v", err)
+	}
+	want := make([]byte, keySize)
+	want[keySize-1] = 1
+	if !bytes.Equal(iv, want) {
+		t.Errorf("got %x, want %x", iv, want)
+	}
+}
+
+func TestResolveIVExplicitHex(t *testing.T) {
+	iv, err := ResolveIV("0x000102030405060708090a0b0c0d0e0f", 0)
+	if err != nil {
+		t.Fatalf("ResolveIV: %v", err)
+	}
+	want := []byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 0xa, 0xb, 0xc, 0xd, 0xe, 0xf}
+	if !bytes.Equal(iv, want) {
+		t.Errorf("got %x, want %x", iv, want)
+	}
+}
@@ -1,24 +1,74 @@
+// Package headers applies per-host rules to the headers flowing between
+// client, proxy and origin: required header injection, a strip-list for
+// headers forwarded from the client, response-header rewrites, and a
+// per-host cookie jar that replays a bootstrap response's Set-Cookie onto
+// later requests to the same host.
 package headers
 
 import (
+	"net/http"
+	"net/http/cookiejar"
 	"net/url"
 	"regexp"
 	"strings"
+	"sync"
+
 	"universalvideoproxy/internal/config"
 )
 
+// hopByHop headers are never forwarded between client and origin - they
+// describe the connection itself, not the resource.
+var hopByHop = map[string]bool{
+	"Connection":          true,
+	"Keep-Alive":          true,
+	"Proxy-Authenticate":  true,
+	"Proxy-Authorization": true,
+	"Te":                  true,
+	"Trailers":            true,
+	"Transfer-Encoding":   true,
+	"Upgrade":             true,
+	"Host":                true,
+}
+
+// IsHopByHop reports whether name is a hop-by-hop header that should never
+// be forwarded between client and origin.
+func IsHopByHop(name string) bool {
+	return hopByHop[http.CanonicalHeaderKey(name)]
+}
+
 type HeaderManager struct {
+	mu    sync.RWMutex
 	rules []config.HeaderRule
+
+	jarMu sync.Mutex
+	jars  map[string]*cookiejar.Jar
 }
 
 func New(rules []config.HeaderRule) *HeaderManager {
-	return &HeaderManager{rules: rules}
+	return &HeaderManager{rules: rules, jars: make(map[string]*cookiejar.Jar)}
+}
+
+// Reload atomically swaps in a new ruleset, for config hot-reload.
+func (hm *HeaderManager) Reload(rules []config.HeaderRule) {
+	hm.mu.Lock()
+	defer hm.mu.Unlock()
+	hm.rules = rules
+}
+
+func (hm *HeaderManager) rulesFor() []config.HeaderRule {
+	hm.mu.RLock()
+	defer hm.mu.RUnlock()
+	return hm.rules
 }
 
+// ProcessHeaders builds the header set to send upstream for targetURL: it
+// starts from originalHeaders, applies every matching rule's Set overrides,
+// and - for a matching rule with CookieJar enabled - attaches any cookies
+// captured from a previous response to the same host. HostRewrite is handled
+// separately by ApplyHostRewrite, since it has to land on req.Host rather
+// than a header.
 func (hm *HeaderManager) ProcessHeaders(targetURL string, originalHeaders map[string]string) map[string]string {
-	result := make(map[string]string)
-	
-	// Copy original headers
+	result := make(map[string]string, len(originalHeaders))
 	for k, v := range originalHeaders {
 		result[k] = v
 	}
@@ -27,27 +77,135 @@ func (hm *HeaderManager) ProcessHeaders(targetURL string, originalHeaders map[st
 	if err != nil {
 		return result
 	}
-
 	hostname := parsed.Hostname()
 
-	// Apply matching rules
-	for _, rule := range hm.rules {
-		if hm.matchesRule(hostname, rule) {
-			// Apply header overrides
-			for k, v := range rule.Set {
-				result[k] = v
-			}
-			
-			// Handle host rewrite
-			if rule.HostRewrite {
-				result["Host"] = hostname
-			}
+	useJar := false
+	for _, rule := range hm.rulesFor() {
+		if !hm.matchesRule(hostname, rule) {
+			continue
+		}
+		for k, v := range rule.Set {
+			result[k] = v
+		}
+		if rule.CookieJar {
+			useJar = true
+		}
+	}
+
+	if useJar {
+		if cookie := hm.cookieHeader(hostname, parsed); cookie != "" {
+			result["Cookie"] = cookie
 		}
 	}
 
 	return result
 }
 
+// ApplyHostRewrite sets req.Host to targetURL's hostname when a matching
+// rule has HostRewrite set. Go's net/http ignores a "Host" entry in
+// req.Header when writing the request line - it's req.Host or req.URL.Host
+// that controls what's actually sent - so this can't be folded into
+// ProcessHeaders' header map.
+func (hm *HeaderManager) ApplyHostRewrite(targetURL string, req *http.Request) {
+	hostname := hostnameOf(targetURL)
+	for _, rule := range hm.rulesFor() {
+		if rule.HostRewrite && hm.matchesRule(hostname, rule) {
+			req.Host = hostname
+			return
+		}
+	}
+}
+
+// StripRequestHeaders deletes, in place, every header that a matching rule's
+// StripRequestHeaders lists - applied to client headers forwarded verbatim
+// before they reach the origin.
+func (hm *HeaderManager) StripRequestHeaders(targetURL string, header http.Header) {
+	hostname := hostnameOf(targetURL)
+	for _, rule := range hm.rulesFor() {
+		if !hm.matchesRule(hostname, rule) {
+			continue
+		}
+		for _, h := range rule.StripRequestHeaders {
+			header.Del(h)
+		}
+	}
+}
+
+// CaptureCookies records any Set-Cookie entries in upstreamHeader against
+// targetURL's host, for rules with CookieJar enabled, so they can be
+// replayed by a later ProcessHeaders call for the same host.
+func (hm *HeaderManager) CaptureCookies(targetURL string, upstreamHeader http.Header) {
+	cookies := (&http.Response{Header: upstreamHeader}).Cookies()
+	if len(cookies) == 0 {
+		return
+	}
+
+	parsed, err := url.Parse(targetURL)
+	if err != nil {
+		return
+	}
+	hostname := parsed.Hostname()
+
+	for _, rule := range hm.rulesFor() {
+		if rule.CookieJar && hm.matchesRule(hostname, rule) {
+			hm.jarFor(hostname).SetCookies(parsed, cookies)
+			return
+		}
+	}
+}
+
+// RewriteResponseHeaders applies ResponseSet overrides and
+// StripResponseHeaders removals, in place, to the headers about to be sent
+// to the client.
+func (hm *HeaderManager) RewriteResponseHeaders(targetURL string, header http.Header) {
+	hostname := hostnameOf(targetURL)
+	for _, rule := range hm.rulesFor() {
+		if !hm.matchesRule(hostname, rule) {
+			continue
+		}
+		for _, h := range rule.StripResponseHeaders {
+			header.Del(h)
+		}
+		for k, v := range rule.ResponseSet {
+			header.Set(k, v)
+		}
+	}
+}
+
+func (hm *HeaderManager) cookieHeader(hostname string, targetURL *url.URL) string {
+	cookies := hm.jarFor(hostname).Cookies(targetURL)
+	if len(cookies) == 0 {
+		return ""
+	}
+	pairs := make([]string, len(cookies))
+	for i, c := range cookies {
+		pairs[i] = c.Name + "=" + c.Value
+	}
+	return strings.Join(pairs, "; ")
+}
+
+func (hm *HeaderManager) jarFor(hostname string) *cookiejar.Jar {
+	hm.jarMu.Lock()
+	defer hm.jarMu.Unlock()
+
+	if jar, ok := hm.jars[hostname]; ok {
+		return jar
+	}
+	// cookiejar.New(nil) only errors on an invalid PublicSuffixList, and we
+	// pass none.
+	jar, _ := cookiejar.New(nil)
+	hm.jars[hostname] = jar
+	return jar
+}
+
+func hostnameOf(targetURL string) string {
+	parsed, err := url.Parse(targetURL)
+	if err != nil {
+		return ""
+	}
+	return parsed.Hostname()
+}
+
 func (hm *HeaderManager) matchesRule(hostname string, rule config.HeaderRule) bool {
 	if rule.UseRegex {
 		if matched, err := regexp.MatchString(rule.Match, hostname); err == nil && matched {
@@ -59,4 +217,4 @@ func (hm *HeaderManager) matchesRule(hostname string, rule config.HeaderRule) bo
 		}
 	}
 	return false
-}
\ No newline at end of file
+}
@@ -0,0 +1,212 @@
+// Package limiter throttles clients with a per-visitor rate limiter and
+// in-flight semaphore, keyed by the client's IP (honoring X-Forwarded-For
+// only from trusted proxies), plus global caps shared across all visitors.
+package limiter
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Config configures a Limiter.
+type Config struct {
+	// RPS and Burst size the per-visitor request-rate limiter.
+	RPS   float64
+	Burst int
+	// MaxInFlight caps concurrent in-progress requests per visitor.
+	MaxInFlight int
+	// BytesPerSecond caps each visitor's outbound response byte rate; 0 disables it.
+	BytesPerSecond int
+	// MaxConcurrentUpstream caps in-progress requests across all visitors; 0 disables it.
+	// Every admitted request holds a slot until it finishes, whether it is
+	// served from cache or fetched upstream.
+	MaxConcurrentUpstream int
+	// TrustedProxies lists CIDRs allowed to set X-Forwarded-For.
+	TrustedProxies []string
+	// IdleTimeout and SweepInterval control eviction of stale visitor entries.
+	IdleTimeout   time.Duration
+	SweepInterval time.Duration
+}
+
+type visitor struct {
+	limiter  *rate.Limiter
+	byteLim  *rate.Limiter
+	inFlight chan struct{}
+	lastSeen time.Time
+}
+
+// Limiter tracks per-visitor rate limiters/semaphores plus global caps.
+type Limiter struct {
+	cfg         Config
+	trustedNets []*net.IPNet
+
+	mu       sync.Mutex
+	visitors map[string]*visitor
+
+	upstream chan struct{}
+}
+
+// New builds a Limiter from cfg and starts its idle-visitor sweeper.
+func New(cfg Config) *Limiter {
+	if cfg.IdleTimeout <= 0 {
+		cfg.IdleTimeout = 5 * time.Minute
+	}
+	if cfg.SweepInterval <= 0 {
+		cfg.SweepInterval = time.Minute
+	}
+
+	l := &Limiter{
+		cfg:         cfg,
+		trustedNets: parseCIDRs(cfg.TrustedProxies),
+		visitors:    make(map[string]*visitor),
+	}
+	if cfg.MaxConcurrentUpstream > 0 {
+		l.upstream = make(chan struct{}, cfg.MaxConcurrentUpstream)
+	}
+
+	go l.sweepLoop()
+	return l
+}
+
+func parseCIDRs(cidrs []string) []*net.IPNet {
+	nets := make([]*net.IPNet, 0, len(cidrs))
+	for _, c := range cidrs {
+		if _, n, err := net.ParseCIDR(c); err == nil {
+			nets = append(nets, n)
+		}
+	}
+	return nets
+}
+
+// ClientKey resolves the visitor key for r: RemoteAddr's IP, or the
+// left-most X-Forwarded-For entry when RemoteAddr is a trusted proxy.
+func (l *Limiter) ClientKey(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	if l.isTrustedProxy(host) {
+		if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
+			if first := strings.TrimSpace(strings.Split(xff, ",")[0]); first != "" {
+				return first
+			}
+		}
+	}
+
+	return host
+}
+
+func (l *Limiter) isTrustedProxy(host string) bool {
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, n := range l.trustedNets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Result is returned by Allow; Release must be called once the request
+// finishes to free the in-flight and global upstream slots it acquired.
+type Result struct {
+	Release     func()
+	ByteLimiter *rate.Limiter
+}
+
+// ErrRateLimited and ErrTooManyConcurrent report which cap Allow hit so
+// callers can set an appropriate Retry-After.
+var (
+	ErrRateLimited       = fmt.Errorf("rate limit exceeded")
+	ErrTooManyConcurrent = fmt.Errorf("too many concurrent requests")
+)
+
+// Allow admits a request from key, or returns an error naming the cap that
+// was hit. On success, callers must call Result.Release when done.
+func (l *Limiter) Allow(key string) (*Result, error) {
+	v := l.visitorFor(key)
+
+	if !v.limiter.Allow() {
+		return nil, ErrRateLimited
+	}
+
+	select {
+	case v.inFlight <- struct{}{}:
+	default:
+		return nil, ErrTooManyConcurrent
+	}
+
+	if l.upstream != nil {
+		select {
+		case l.upstream <- struct{}{}:
+		default:
+			<-v.inFlight
+			return nil, ErrTooManyConcurrent
+		}
+	}
+
+	release := func() {
+		<-v.inFlight
+		if l.upstream != nil {
+			<-l.upstream
+		}
+	}
+
+	return &Result{Release: release, ByteLimiter: v.byteLim}, nil
+}
+
+func (l *Limiter) visitorFor(key string) *visitor {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	v, found := l.visitors[key]
+	if !found {
+		v = &visitor{
+			limiter:  rate.NewLimiter(rate.Limit(l.cfg.RPS), l.cfg.Burst),
+			inFlight: make(chan struct{}, maxInt(l.cfg.MaxInFlight, 1)),
+		}
+		if l.cfg.BytesPerSecond > 0 {
+			v.byteLim = rate.NewLimiter(rate.Limit(l.cfg.BytesPerSecond), l.cfg.BytesPerSecond)
+		}
+		l.visitors[key] = v
+	}
+	v.lastSeen = time.Now()
+	return v
+}
+
+func (l *Limiter) sweepLoop() {
+	ticker := time.NewTicker(l.cfg.SweepInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		l.sweep()
+	}
+}
+
+func (l *Limiter) sweep() {
+	cutoff := time.Now().Add(-l.cfg.IdleTimeout)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for key, v := range l.visitors {
+		if v.lastSeen.Before(cutoff) {
+			delete(l.visitors, key)
+		}
+	}
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,45 @@
+package limiter
+
+import (
+	"context"
+	"net/http"
+
+	"golang.org/x/time/rate"
+)
+
+// ThrottledWriter wraps an http.ResponseWriter so that Write blocks until
+// lim admits the bytes being written, capping the response's byte rate.
+// A nil lim makes Write a passthrough.
+type ThrottledWriter struct {
+	http.ResponseWriter
+	lim *rate.Limiter
+}
+
+// NewThrottledWriter wraps w with lim. lim may be nil to disable throttling.
+func NewThrottledWriter(w http.ResponseWriter, lim *rate.Limiter) *ThrottledWriter {
+	return &ThrottledWriter{ResponseWriter: w, lim: lim}
+}
+
+func (t *ThrottledWriter) Write(p []byte) (int, error) {
+	if t.lim == nil {
+		return t.ResponseWriter.Write(p)
+	}
+
+	burst := t.lim.Burst()
+	written := 0
+	for written < len(p) {
+		chunk := len(p) - written
+		if chunk > burst {
+			chunk = burst
+		}
+		if err := t.lim.WaitN(context.Background(), chunk); err != nil {
+			return written, err
+		}
+		n, err := t.ResponseWriter.Write(p[written : written+chunk])
+		written += n
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
@@ -0,0 +1,71 @@
+// Package metrics holds process-wide counters exposed on /metrics in
+// Prometheus text exposition format.
+package metrics
+
+import (
+	"fmt"
+	"sync/atomic"
+)
+
+type Counters struct {
+	cacheHits          int64
+	cacheMisses        int64
+	singleflightShared int64
+	negativeCacheHits  int64
+	upstreamH2         int64
+	upstreamH3         int64
+	upstreamH3Fallback int64
+}
+
+func (c *Counters) IncCacheHit() { atomic.AddInt64(&c.cacheHits, 1) }
+
+func (c *Counters) IncCacheMiss() { atomic.AddInt64(&c.cacheMisses, 1) }
+
+func (c *Counters) IncSingleflightShared() { atomic.AddInt64(&c.singleflightShared, 1) }
+
+func (c *Counters) IncNegativeCacheHit() { atomic.AddInt64(&c.negativeCacheHits, 1) }
+
+// IncUpstreamProtocol records which transport served an upstream fetch.
+func (c *Counters) IncUpstreamProtocol(proto string) {
+	switch proto {
+	case "h3":
+		atomic.AddInt64(&c.upstreamH3, 1)
+	default:
+		atomic.AddInt64(&c.upstreamH2, 1)
+	}
+}
+
+// IncUpstreamH3Fallback records a QUIC fetch that failed and fell back to H2.
+func (c *Counters) IncUpstreamH3Fallback() { atomic.AddInt64(&c.upstreamH3Fallback, 1) }
+
+// Render formats the counters as Prometheus text exposition.
+func (c *Counters) Render() string {
+	return fmt.Sprintf(
+		"# HELP cache_hits_total Cache hits across all caches\n"+
+			"# TYPE cache_hits_total counter\n"+
+			"cache_hits_total %d\n"+
+			"# HELP cache_misses_total Cache misses across all caches\n"+
+			"# TYPE cache_misses_total counter\n"+
+			"cache_misses_total %d\n"+
+			"# HELP singleflight_shared_total Upstream fetches coalesced via singleflight\n"+
+			"# TYPE singleflight_shared_total counter\n"+
+			"singleflight_shared_total %d\n"+
+			"# HELP negative_cache_hits_total Requests short-circuited by negative caching\n"+
+			"# TYPE negative_cache_hits_total counter\n"+
+			"negative_cache_hits_total %d\n"+
+			"# HELP upstream_requests_total Upstream fetches by transport protocol\n"+
+			"# TYPE upstream_requests_total counter\n"+
+			"upstream_requests_total{protocol=\"h2\"} %d\n"+
+			"upstream_requests_total{protocol=\"h3\"} %d\n"+
+			"# HELP upstream_h3_fallback_total QUIC fetches that failed and fell back to H2\n"+
+			"# TYPE upstream_h3_fallback_total counter\n"+
+			"upstream_h3_fallback_total %d\n",
+		atomic.LoadInt64(&c.cacheHits),
+		atomic.LoadInt64(&c.cacheMisses),
+		atomic.LoadInt64(&c.singleflightShared),
+		atomic.LoadInt64(&c.negativeCacheHits),
+		atomic.LoadInt64(&c.upstreamH2),
+		atomic.LoadInt64(&c.upstreamH3),
+		atomic.LoadInt64(&c.upstreamH3Fallback),
+	)
+}
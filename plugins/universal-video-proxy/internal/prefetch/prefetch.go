@@ -0,0 +1,124 @@
+// Package prefetch eagerly warms a cache with a live HLS playlist's
+// newly-appeared segment/map URIs ahead of client requests, and evicts
+// entries that slide out of the live window - mirroring the segment-queue
+// approach used by HLS clients like mediamtx to cut first-segment latency.
+package prefetch
+
+import (
+	"sync"
+	"time"
+)
+
+// CacheSetter is the subset of internal/cache.Cache the prefetcher needs.
+type CacheSetter interface {
+	Set(key string, data []byte)
+	Delete(key string)
+}
+
+// FetchFunc retrieves the bytes at url for warming into the cache.
+type FetchFunc func(url string) ([]byte, error)
+
+// Config controls prefetch concurrency.
+type Config struct {
+	// MaxConcurrentPerPlaylist caps in-flight prefetch fetches for a single
+	// playlist. Defaults to 2 if unset.
+	MaxConcurrentPerPlaylist int
+}
+
+type playlistState struct {
+	mu    sync.Mutex
+	known map[string]bool
+	sem   chan struct{}
+}
+
+// Prefetcher tracks, per playlist, the set of segment/map URIs last seen so
+// it can diff against the next refresh.
+type Prefetcher struct {
+	cfg Config
+
+	mu        sync.Mutex
+	playlists map[string]*playlistState
+}
+
+// New creates a Prefetcher.
+func New(cfg Config) *Prefetcher {
+	if cfg.MaxConcurrentPerPlaylist <= 0 {
+		cfg.MaxConcurrentPerPlaylist = 2
+	}
+	return &Prefetcher{cfg: cfg, playlists: make(map[string]*playlistState)}
+}
+
+func (p *Prefetcher) stateFor(playlistKey string) *playlistState {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	st, found := p.playlists[playlistKey]
+	if !found {
+		st = &playlistState{
+			known: make(map[string]bool),
+			sem:   make(chan struct{}, p.cfg.MaxConcurrentPerPlaylist),
+		}
+		p.playlists[playlistKey] = st
+	}
+	return st
+}
+
+// OnRefresh diffs currentURIs - the segment/map URIs present in the
+// playlist just fetched for playlistKey, in playlist order - against what
+// was seen on the previous refresh. URIs that are new are fetched in the
+// background and stored in cache, paced across targetDurationSeconds so
+// they don't all hit the origin at once; URIs that fell out of the window
+// are evicted from cache immediately.
+func (p *Prefetcher) OnRefresh(playlistKey string, currentURIs []string, targetDurationSeconds float64, fetch FetchFunc, cache CacheSetter) {
+	st := p.stateFor(playlistKey)
+
+	st.mu.Lock()
+	current := make(map[string]bool, len(currentURIs))
+	var fresh []string
+	for _, uri := range currentURIs {
+		current[uri] = true
+		if !st.known[uri] {
+			fresh = append(fresh, uri)
+		}
+	}
+	var gone []string
+	for uri := range st.known {
+		if !current[uri] {
+			gone = append(gone, uri)
+		}
+	}
+	st.known = current
+	st.mu.Unlock()
+
+	for _, uri := range gone {
+		cache.Delete(uri)
+	}
+
+	if len(fresh) == 0 {
+		return
+	}
+
+	pace := time.Duration(0)
+	if targetDurationSeconds > 0 {
+		pace = time.Duration(targetDurationSeconds*float64(time.Second)) / time.Duration(len(fresh))
+	}
+
+	for i, uri := range fresh {
+		uri := uri
+		delay := pace * time.Duration(i)
+		go func() {
+			if delay > 0 {
+				time.Sleep(delay)
+			}
+
+			st.sem <- struct{}{}
+			defer func() { <-st.sem }()
+
+			data, err := fetch(uri)
+			if err != nil {
+				return
+			}
+			cache.Set(uri, data)
+		}()
+	}
+}
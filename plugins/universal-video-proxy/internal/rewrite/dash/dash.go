@@ -0,0 +1,201 @@
+// Package dash rewrites MPEG-DASH manifests (.mpd) so every BaseURL and
+// SegmentList SourceURL resolves through the proxy, mirroring what
+// rewrite.M3U8Rewriter does for HLS playlists.
+//
+// Rewrite works as a token-level streaming copy rather than an
+// Unmarshal-into-a-struct/Marshal round-trip: a declared-struct model only
+// covers the handful of fields it names, so anything it doesn't know about
+// (xmlns, profiles, @type, durations, ContentProtection, SegmentTimeline,
+// Representation attributes, ...) would be silently dropped on the way back
+// out. Copying every token through, and only rewriting the BaseURL/sourceURL
+// values this package cares about, keeps every element, attribute and text
+// node of the manifest regardless of what it contains. The output is
+// re-serialized rather than byte-identical: empty elements are written as
+// start/end tag pairs and namespace prefixes on attributes may be renamed.
+package dash
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+)
+
+// BuildProxyURL builds a proxy BaseURL that, once the player appends a
+// relative template/segment path to it, resolves back to proxyPrefix with
+// the real upstream base embedded in the path. The trailing slash is load
+// bearing: it's what lets a player's naive string concatenation work.
+func BuildProxyURL(proxyPrefix, base string) string {
+	return strings.TrimSuffix(proxyPrefix, "/") + "/" + url.PathEscape(base) + "/"
+}
+
+// baseScopedElements are the elements a BaseURL's scope nests under: a
+// BaseURL found directly inside one of these applies to that element and
+// everything beneath it, same as the MPD/Period/AdaptationSet/Representation
+// hierarchy in ISO/IEC 23009-1.
+var baseScopedElements = map[string]bool{
+	"MPD":            true,
+	"Period":         true,
+	"AdaptationSet":  true,
+	"Representation": true,
+}
+
+// Rewrite copies content token-by-token, rewriting every BaseURL element and
+// SegmentList SourceURL/Initialization attribute to point at the proxy while
+// leaving everything else - including elements and attributes this package
+// doesn't otherwise look at - exactly as it was. SegmentTemplate's
+// media/initialization attributes are left untouched: their
+// $Number$/$Time$/$RepresentationID$ templating only means something once
+// resolved against the (rewritten) surrounding BaseURL.
+func Rewrite(content []byte, originalURL, proxyPrefix string) ([]byte, error) {
+	dec := xml.NewDecoder(bytes.NewReader(content))
+	var out bytes.Buffer
+	enc := xml.NewEncoder(&out)
+
+	baseStack := []string{originalURL}
+	// nsStack tracks the default namespace in scope in the output, so an
+	// element in the same namespace as its parent is written without
+	// re-declaring it; xml.Encoder otherwise emits xmlns="..." on every
+	// namespaced element.
+	nsStack := []string{""}
+
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("parse mpd: %w", err)
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if baseScopedElements[t.Name.Local] {
+				baseStack = append(baseStack, baseStack[len(baseStack)-1])
+			}
+			t.Attr = stripXMLNSAttrs(t.Attr)
+			ns := t.Name.Space
+			if ns != "" && ns == nsStack[len(nsStack)-1] {
+				t.Name.Space = ""
+			}
+
+			if t.Name.Local == "BaseURL" {
+				var text string
+				if err := dec.DecodeElement(&text, &t); err != nil {
+					return nil, fmt.Errorf("parse mpd: decode BaseURL: %w", err)
+				}
+				resolved := resolve(strings.TrimSpace(text), baseStack[len(baseStack)-1])
+				baseStack[len(baseStack)-1] = resolved
+				if err := encodeElement(enc, t, BuildProxyURL(proxyPrefix, resolved)); err != nil {
+					return nil, fmt.Errorf("serialize mpd: %w", err)
+				}
+				continue
+			}
+			nsStack = append(nsStack, ns)
+
+			if t.Name.Local == "SegmentURL" || t.Name.Local == "Initialization" {
+				rewriteSourceURL(&t, baseStack[len(baseStack)-1], proxyPrefix)
+			}
+
+			if err := enc.EncodeToken(t); err != nil {
+				return nil, fmt.Errorf("serialize mpd: %w", err)
+			}
+
+		case xml.EndElement:
+			if baseScopedElements[t.Name.Local] {
+				baseStack = baseStack[:len(baseStack)-1]
+			}
+			nsStack = nsStack[:len(nsStack)-1]
+			if t.Name.Space != "" && t.Name.Space == nsStack[len(nsStack)-1] {
+				t.Name.Space = ""
+			}
+			if err := enc.EncodeToken(t); err != nil {
+				return nil, fmt.Errorf("serialize mpd: %w", err)
+			}
+
+		case xml.ProcInst:
+			// The leading <?xml ...?> is emitted once, up front, via the
+			// hardcoded xml.Header below - re-encoding the decoded ProcInst
+			// here would duplicate it.
+			if t.Target == "xml" {
+				continue
+			}
+			if err := enc.EncodeToken(tok); err != nil {
+				return nil, fmt.Errorf("serialize mpd: %w", err)
+			}
+
+		default:
+			if err := enc.EncodeToken(tok); err != nil {
+				return nil, fmt.Errorf("serialize mpd: %w", err)
+			}
+		}
+	}
+
+	if err := enc.Flush(); err != nil {
+		return nil, fmt.Errorf("serialize mpd: %w", err)
+	}
+	return append([]byte(xml.Header), out.Bytes()...), nil
+}
+
+// stripXMLNSAttrs removes xmlns/xmlns:* declarations from attrs. The decoder
+// surfaces them as ordinary attributes, but xml.Encoder independently
+// re-derives and re-emits a namespace declaration for any element/attribute
+// whose Name.Space is set - encoding both leaves e.g. `xmlns="..."
+// xmlns="..."` on the same start tag, which no XML parser accepts.
+func stripXMLNSAttrs(attrs []xml.Attr) []xml.Attr {
+	out := attrs[:0]
+	for _, attr := range attrs {
+		if attr.Name.Local == "xmlns" || attr.Name.Space == "xmlns" {
+			continue
+		}
+		out = append(out, attr)
+	}
+	return out
+}
+
+// encodeElement writes <start>text</start> as explicit tokens.
+func encodeElement(enc *xml.Encoder, start xml.StartElement, text string) error {
+	if err := enc.EncodeToken(start); err != nil {
+		return err
+	}
+	if err := enc.EncodeToken(xml.CharData(text)); err != nil {
+		return err
+	}
+	return enc.EncodeToken(xml.EndElement{Name: start.Name})
+}
+
+// rewriteSourceURL rewrites a SegmentURL/Initialization element's sourceURL
+// attribute in place, resolved against base.
+func rewriteSourceURL(start *xml.StartElement, base, proxyPrefix string) {
+	for i, attr := range start.Attr {
+		if attr.Name.Local == "sourceURL" && attr.Value != "" {
+			start.Attr[i].Value = BuildProxyURL(proxyPrefix, resolve(attr.Value, base))
+		}
+	}
+}
+
+func resolve(ref, base string) string {
+	if strings.HasPrefix(ref, "http://") || strings.HasPrefix(ref, "https://") {
+		return ref
+	}
+	baseURL, err := url.Parse(base)
+	if err != nil {
+		return ref
+	}
+	resolved, err := baseURL.Parse(ref)
+	if err != nil {
+		return ref
+	}
+	return resolved.String()
+}
+
+// IsDASHContent reports whether content looks like an MPEG-DASH manifest.
+func IsDASHContent(content []byte) bool {
+	head := content
+	if len(head) > 512 {
+		head = head[:512]
+	}
+	return strings.Contains(string(head), "<MPD")
+}
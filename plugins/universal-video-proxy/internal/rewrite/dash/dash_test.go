@@ -0,0 +1,86 @@
+package dash
+
+import (
+	"encoding/xml"
+	"strings"
+	"testing"
+)
+
+const namespacedMPD = `<?xml version="1.0" encoding="UTF-8"?>
+<MPD xmlns="urn:mpeg:dash:schema:mpd:2011" profiles="urn:mpeg:dash:profile:isoff-on-demand:2011" type="static" mediaPresentationDuration="PT1M0S" minBufferTime="PT2S">
+  <Period>
+    <BaseURL>segments/</BaseURL>
+    <AdaptationSet mimeType="video/mp4" segmentAlignment="true">
+      <ContentProtection schemeIdUri="urn:mpeg:dash:mp4protection:2011" cenc:default_KID="00000000-0000-0000-0000-000000000000" xmlns:cenc="urn:mpeg:cenc:2013"/>
+      <Representation id="v0" bandwidth="800000" codecs="avc1.64001f" width="1280" height="720">
+        <SegmentList>
+          <Initialization sourceURL="init.mp4"/>
+          <SegmentURL sourceURL="seg1.m4s"/>
+          <SegmentURL sourceURL="seg2.m4s"/>
+        </SegmentList>
+      </Representation>
+    </AdaptationSet>
+  </Period>
+</MPD>`
+
+func TestRewriteNamespacedMPDRoundTrips(t *testing.T) {
+	out, err := Rewrite([]byte(namespacedMPD), "https://origin.example/path/manifest.mpd", "/dashseg")
+	if err != nil {
+		t.Fatalf("Rewrite: %v", err)
+	}
+
+	if n := strings.Count(string(out), "xmlns=\"urn:mpeg:dash:schema:mpd:2011\""); n != 1 {
+		t.Errorf("expected exactly one default-namespace declaration, got %d in:\n%s", n, out)
+	}
+	if n := strings.Count(string(out), "<?xml"); n != 1 {
+		t.Errorf("expected exactly one XML declaration, got %d in:\n%s", n, out)
+	}
+
+	var reparsed MPDForTest
+	if err := xml.Unmarshal(out, &reparsed); err != nil {
+		t.Fatalf("rewritten manifest is not well-formed XML: %v\n%s", err, out)
+	}
+}
+
+// MPDForTest only checks that the output re-parses as well-formed XML and
+// that the fields Rewrite is responsible for were actually rewritten; it
+// intentionally doesn't attempt to model the whole schema.
+type MPDForTest struct {
+	Periods []struct {
+		BaseURL        string `xml:"BaseURL"`
+		AdaptationSets []struct {
+			Representations []struct {
+				SegmentList struct {
+					Initialization struct {
+						SourceURL string `xml:"sourceURL,attr"`
+					} `xml:"Initialization"`
+					SegmentURLs []struct {
+						SourceURL string `xml:"sourceURL,attr"`
+					} `xml:"SegmentURL"`
+				} `xml:"SegmentList"`
+			} `xml:"Representation"`
+		} `xml:"AdaptationSet"`
+	} `xml:"Period"`
+}
+
+func TestRewriteRewritesURLs(t *testing.T) {
+	out, err := Rewrite([]byte(namespacedMPD), "https://origin.example/path/manifest.mpd", "/dashseg")
+	if err != nil {
+		t.Fatalf("Rewrite: %v", err)
+	}
+
+	var mpd MPDForTest
+	if err := xml.Unmarshal(out, &mpd); err != nil {
+		t.Fatalf("unmarshal rewritten manifest: %v", err)
+	}
+
+	rep := mpd.Periods[0].AdaptationSets[0].Representations[0]
+	if !strings.HasPrefix(rep.SegmentList.Initialization.SourceURL, "/dashseg/") {
+		t.Errorf("Initialization sourceURL not rewritten: %q", rep.SegmentList.Initialization.SourceURL)
+	}
+	for _, su := range rep.SegmentList.SegmentURLs {
+		if !strings.HasPrefix(su.SourceURL, "/dashseg/") {
+			t.Errorf("SegmentURL sourceURL not rewritten: %q", su.SourceURL)
+		}
+	}
+}
@@ -1,72 +1,240 @@
+// Package rewrite rewrites HLS playlists so every URI a client can follow
+// (segments, keys, maps, alternate renditions, variant playlists) routes
+// back through the proxy. It parses with grafov/m3u8 rather than hand-rolled
+// regexes so MasterPlaylist vs MediaPlaylist semantics, byte ranges,
+// discontinuities and independent-segments markers all round-trip correctly.
 package rewrite
 
 import (
-	"bufio"
+	"bytes"
+	"encoding/hex"
 	"fmt"
 	"net/url"
+	"regexp"
+	"strconv"
 	"strings"
+
+	"github.com/grafov/m3u8"
+
+	"universalvideoproxy/internal/drm"
 )
 
+// newBuffer wraps content in a *bytes.Buffer (sharing its bytes, not copying
+// them) for m3u8.Decode, which takes its input buffer by value.
+func newBuffer(content []byte) *bytes.Buffer {
+	return bytes.NewBuffer(content)
+}
+
+// VariantPolicy controls how master-playlist variants are rewritten.
+type VariantPolicy struct {
+	KeepAll bool
+	// Select picks which single variant survives when KeepAll is false:
+	// "min" or "max" by BANDWIDTH, or "target" for the variant closest to
+	// TargetBitrate. Defaults to "max".
+	Select        string
+	TargetBitrate int
+}
+
 type M3U8Rewriter struct {
-	baseURL    string
-	segPrefix  string
-	keyPrefix  string
-	signParams string
+	baseURL        string
+	segPrefix      string
+	keyPrefix      string
+	playlistPrefix string
+	signParams     string
+	variants       VariantPolicy
+	// drmSession, when set, routes EXT-X-KEY URIs through the session-scoped
+	// key endpoint (internal/drm) instead of proxying the upstream key
+	// as-is, and tags each segment URL with the upstream key/IV it needs
+	// decrypted and re-encrypted with.
+	drmSession bool
 }
 
-func NewM3U8Rewriter(baseURL, segPrefix, keyPrefix, signParams string) *M3U8Rewriter {
+func NewM3U8Rewriter(baseURL, segPrefix, keyPrefix, playlistPrefix, signParams string, variants VariantPolicy, drmSession bool) *M3U8Rewriter {
 	return &M3U8Rewriter{
-		baseURL:    baseURL,
-		segPrefix:  segPrefix,
-		keyPrefix:  keyPrefix,
-		signParams: signParams,
+		baseURL:        baseURL,
+		segPrefix:      segPrefix,
+		keyPrefix:      keyPrefix,
+		playlistPrefix: playlistPrefix,
+		signParams:     signParams,
+		variants:       variants,
+		drmSession:     drmSession,
 	}
 }
 
+// Rewrite parses content as either a master or media playlist and rewrites
+// every URI it carries to a proxy URL resolved against originalURL.
+//
+// grafov/m3u8 doesn't model EXT-X-SESSION-KEY (master) or EXT-X-PART
+// (media, LL-HLS) and silently drops them on decode, so those lines are
+// extracted from the raw text first, rewritten with the same URI builders
+// as everything else, and spliced back into grafov's output afterward.
 func (r *M3U8Rewriter) Rewrite(content []byte, originalURL string) ([]byte, error) {
-	lines := strings.Split(string(content), "\n")
-	var result []string
+	stripped, sessionKeyLines := extractTaggedLines(content, "#EXT-X-SESSION-KEY:")
+	stripped, partsBySegment, trailingParts := extractPartLines(stripped)
 
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
-		
-		if line == "" || strings.HasPrefix(line, "#") {
-			// Handle special directives
-			if strings.HasPrefix(line, "#EXT-X-KEY:") {
-				line = r.rewriteKeyLine(line, originalURL)
+	playlist, listType, err := m3u8.Decode(*newBuffer(stripped), true)
+	if err != nil {
+		return nil, fmt.Errorf("decode m3u8: %w", err)
+	}
+
+	switch listType {
+	case m3u8.MASTER:
+		out, err := r.rewriteMaster(playlist.(*m3u8.MasterPlaylist), originalURL)
+		if err != nil {
+			return nil, err
+		}
+		return r.spliceSessionKeys(out, sessionKeyLines, originalURL), nil
+	default:
+		out, err := r.rewriteMedia(playlist.(*m3u8.MediaPlaylist), originalURL)
+		if err != nil {
+			return nil, err
+		}
+		return r.splicePartTags(out, partsBySegment, trailingParts, originalURL), nil
+	}
+}
+
+func (r *M3U8Rewriter) rewriteMaster(pl *m3u8.MasterPlaylist, baseURL string) ([]byte, error) {
+	if !r.variants.KeepAll && len(pl.Variants) > 0 {
+		// EXT-X-I-FRAME-STREAM-INF entries parse into the same Variants slice
+		// (grafov doesn't separate them) but are trick-play-only and always
+		// advertise a much lower bandwidth than the matching real variant -
+		// candidates for selectVariant must exclude them or "min"/"target"
+		// can pick an I-frame stream as the sole surviving, unplayable
+		// variant.
+		candidates := nonIframeVariants(pl.Variants)
+		if len(candidates) == 0 {
+			candidates = pl.Variants
+		}
+		pl.Variants = []*m3u8.Variant{selectVariant(candidates, r.variants)}
+	}
+
+	for _, v := range pl.Variants {
+		if v.URI != "" {
+			v.URI = r.buildPlaylistURL(r.resolveURL(v.URI, baseURL))
+		}
+		for _, alt := range v.Alternatives {
+			if alt.URI != "" {
+				alt.URI = r.buildPlaylistURL(r.resolveURL(alt.URI, baseURL))
 			}
-			result = append(result, line)
+		}
+	}
+
+	return pl.Encode().Bytes(), nil
+}
+
+// rewriteMedia rewrites every URI-bearing field of a media playlist. Segment
+// order and sequence numbers are left exactly as grafov parsed them, so a
+// live playlist's unchanged segments re-encode to byte-identical proxy URLs
+// across refreshes and stay hot in the TS cache.
+func (r *M3U8Rewriter) rewriteMedia(pl *m3u8.MediaPlaylist, baseURL string) ([]byte, error) {
+	var playlistKeyURL, playlistKeyIV string
+	if pl.Key != nil && pl.Key.URI != "" {
+		playlistKeyURL = r.resolveURL(pl.Key.URI, baseURL)
+		playlistKeyIV = pl.Key.IV
+		pl.Key.URI = r.buildKeyURL(playlistKeyURL)
+	}
+	if pl.Map != nil && pl.Map.URI != "" {
+		pl.Map.URI = r.buildSegmentURL(r.resolveURL(pl.Map.URI, baseURL), playlistKeyURL, playlistKeyIV, pl.SeqNo, "")
+	}
+
+	// lastRangeURL/lastRangeEnd reconstruct the EXT-X-BYTERANGE "continues
+	// from the previous range" default (RFC 8216 4.3.2.2): grafov parses an
+	// omitted offset as 0, indistinguishable from an explicit 0, so we only
+	// apply the previous range's end when this segment repeats the same URI
+	// as the one that set it.
+	var lastRangeURL string
+	var lastRangeEnd int64
+
+	for _, seg := range pl.Segments {
+		if seg == nil {
+			continue
+		}
+
+		// A segment can carry its own EXT-X-KEY, overriding the
+		// playlist-level one; fall back to the playlist's otherwise.
+		keyURL, keyIV := playlistKeyURL, playlistKeyIV
+		if seg.Key != nil && seg.Key.URI != "" {
+			keyURL = r.resolveURL(seg.Key.URI, baseURL)
+			keyIV = seg.Key.IV
+			seg.Key.URI = r.buildKeyURL(keyURL)
+		}
+
+		resolvedURL := r.resolveURL(seg.URI, baseURL)
+
+		var rangeParam string
+		if seg.Limit > 0 {
+			offset := seg.Offset
+			if offset == 0 && resolvedURL == lastRangeURL {
+				offset = lastRangeEnd
+			}
+			rangeParam = fmt.Sprintf("%d-%d", offset, seg.Limit)
+			lastRangeURL, lastRangeEnd = resolvedURL, offset+seg.Limit
 		} else {
-			// This is a segment URL
-			segmentURL := r.resolveURL(line, originalURL)
-			proxyURL := r.buildSegmentURL(segmentURL)
-			result = append(result, proxyURL)
+			lastRangeURL = ""
+		}
+
+		seg.URI = r.buildSegmentURL(resolvedURL, keyURL, keyIV, seg.SeqId, rangeParam)
+		if seg.Map != nil && seg.Map.URI != "" {
+			seg.Map.URI = r.buildSegmentURL(r.resolveURL(seg.Map.URI, baseURL), keyURL, keyIV, seg.SeqId, "")
 		}
 	}
 
-	return []byte(strings.Join(result, "\n")), nil
+	return pl.Encode().Bytes(), nil
 }
 
-func (r *M3U8Rewriter) rewriteKeyLine(line, baseURL string) string {
-	// Extract URI from EXT-X-KEY line
-	parts := strings.Split(line, ",")
-	for i, part := range parts {
-		part = strings.TrimSpace(part)
-		if strings.HasPrefix(part, "URI=") {
-			// Extract the quoted URI
-			uriPart := part[4:] // Remove "URI="
-			if len(uriPart) >= 2 && uriPart[0] == '"' && uriPart[len(uriPart)-1] == '"' {
-				uri := uriPart[1 : len(uriPart)-1] // Remove quotes
-				resolvedURI := r.resolveURL(uri, baseURL)
-				proxyURI := r.buildKeyURL(resolvedURI)
-				parts[i] = fmt.Sprintf("URI=\"%s\"", proxyURI)
+// nonIframeVariants returns the variants that aren't EXT-X-I-FRAME-STREAM-INF
+// trick-play entries.
+func nonIframeVariants(variants []*m3u8.Variant) []*m3u8.Variant {
+	out := make([]*m3u8.Variant, 0, len(variants))
+	for _, v := range variants {
+		if v.VariantParams.Iframe {
+			continue
+		}
+		out = append(out, v)
+	}
+	return out
+}
+
+func selectVariant(variants []*m3u8.Variant, policy VariantPolicy) *m3u8.Variant {
+	best := variants[0]
+
+	switch policy.Select {
+	case "min":
+		for _, v := range variants {
+			if v.Bandwidth < best.Bandwidth {
+				best = v
 			}
 		}
+	case "target":
+		bestDiff := absInt(int(best.Bandwidth) - policy.TargetBitrate)
+		for _, v := range variants {
+			if d := absInt(int(v.Bandwidth) - policy.TargetBitrate); d < bestDiff {
+				best, bestDiff = v, d
+			}
+		}
+	default: // "max" or unset
+		for _, v := range variants {
+			if v.Bandwidth > best.Bandwidth {
+				best = v
+			}
+		}
+	}
+
+	return best
+}
+
+func absInt(n int) int {
+	if n < 0 {
+		return -n
 	}
-	return strings.Join(parts, ",")
+	return n
 }
 
 func (r *M3U8Rewriter) resolveURL(urlStr, baseURL string) string {
+	return resolveURL(urlStr, baseURL)
+}
+
+func resolveURL(urlStr, baseURL string) string {
 	if strings.HasPrefix(urlStr, "http://") || strings.HasPrefix(urlStr, "https://") {
 		return urlStr
 	}
@@ -84,35 +252,284 @@ func (r *M3U8Rewriter) resolveURL(urlStr, baseURL string) string {
 	return resolved.String()
 }
 
-func (r *M3U8Rewriter) buildSegmentURL(segmentURL string) string {
+// buildSegmentURL builds this segment's proxy URL. When drmSession is set
+// and the segment is encrypted (keyURL non-empty), it also carries the
+// upstream key URL and the segment's resolved IV, so /seg can decrypt with
+// the real key and re-encrypt with the session key without a second round
+// trip to work out which key/IV applied - the IV in particular is made
+// explicit here even when the origin playlist left it implicit (derived
+// from seqID), since /seg has no playlist context of its own to re-derive it.
+// rangeParam, when non-empty, carries an EXT-X-BYTERANGE's "offset-length"
+// so /seg can translate it into an upstream Range request.
+func (r *M3U8Rewriter) buildSegmentURL(segmentURL, keyURL, keyIV string, seqID uint64, rangeParam string) string {
 	encoded := url.QueryEscape(segmentURL)
 	result := r.baseURL + r.segPrefix + "?u=" + encoded
 	if r.signParams != "" {
 		result += "&" + r.signParams
 	}
+	if r.drmSession && keyURL != "" {
+		if iv, err := drm.ResolveIV(keyIV, seqID); err == nil {
+			result += "&key=" + url.QueryEscape(keyURL) + "&iv=" + hex.EncodeToString(iv)
+		}
+	}
+	if rangeParam != "" {
+		result += "&range=" + rangeParam
+	}
 	return result
 }
 
+// buildPartURL adapts buildSegmentURL to the func(string) string shape
+// rewriteRawURIAttrLine expects, for EXT-X-PART URIs spliced back in by
+// splicePartTags. Parts aren't modeled by grafov, so they're rewritten
+// without DRM/byterange tagging - a known gap for LL-HLS playlists under
+// DRM.Enabled.
+func (r *M3U8Rewriter) buildPartURL(segmentURL string) string {
+	return r.buildSegmentURL(segmentURL, "", "", 0, "")
+}
+
+var uriAttrRe = regexp.MustCompile(`URI="([^"]*)"`)
+
+// rewriteRawURIAttrLine rewrites the URI="..." attribute of a raw playlist
+// line (one grafov doesn't parse into its object model) using build to turn
+// the resolved upstream URL into a proxy URL.
+func (r *M3U8Rewriter) rewriteRawURIAttrLine(line, baseURL string, build func(string) string) string {
+	return uriAttrRe.ReplaceAllStringFunc(line, func(match string) string {
+		sub := uriAttrRe.FindStringSubmatch(match)
+		if len(sub) != 2 {
+			return match
+		}
+		return fmt.Sprintf(`URI="%s"`, build(r.resolveURL(sub[1], baseURL)))
+	})
+}
+
+// extractTaggedLines removes every line whose trimmed form starts with
+// prefix from content, returning the remaining content and the removed
+// lines in their original order.
+func extractTaggedLines(content []byte, prefix string) (stripped []byte, matched []string) {
+	lines := strings.Split(string(content), "\n")
+	kept := make([]string, 0, len(lines))
+	for _, line := range lines {
+		if strings.HasPrefix(strings.TrimSpace(line), prefix) {
+			matched = append(matched, strings.TrimSpace(line))
+			continue
+		}
+		kept = append(kept, line)
+	}
+	return []byte(strings.Join(kept, "\n")), matched
+}
+
+// extractPartLines removes EXT-X-PART lines (LL-HLS partial segments, not
+// modeled by grafov) from content, bucketing each run of them by the index
+// (0-based, in EXTINF order) of the full segment they precede. A run with
+// no following EXTINF - the in-progress final segment's preload parts - is
+// returned separately as trailing.
+func extractPartLines(content []byte) (stripped []byte, partsBySegment map[int][]string, trailing []string) {
+	lines := strings.Split(string(content), "\n")
+	kept := make([]string, 0, len(lines))
+	partsBySegment = make(map[int][]string)
+
+	var pending []string
+	segIndex := 0
+	for _, raw := range lines {
+		line := strings.TrimSpace(raw)
+		switch {
+		case strings.HasPrefix(line, "#EXT-X-PART:"):
+			pending = append(pending, line)
+			continue
+		case strings.HasPrefix(line, "#EXTINF:"):
+			if len(pending) > 0 {
+				partsBySegment[segIndex] = pending
+				pending = nil
+			}
+			segIndex++
+		}
+		kept = append(kept, raw)
+	}
+
+	return []byte(strings.Join(kept, "\n")), partsBySegment, pending
+}
+
+// spliceSessionKeys reinserts rewritten EXT-X-SESSION-KEY lines right after
+// #EXTM3U in a rewritten master playlist.
+func (r *M3U8Rewriter) spliceSessionKeys(output []byte, sessionKeyLines []string, baseURL string) []byte {
+	if len(sessionKeyLines) == 0 {
+		return output
+	}
+
+	lines := strings.Split(string(output), "\n")
+	result := make([]string, 0, len(lines)+len(sessionKeyLines))
+	inserted := false
+	for _, line := range lines {
+		result = append(result, line)
+		if !inserted && strings.TrimSpace(line) == "#EXTM3U" {
+			for _, sk := range sessionKeyLines {
+				result = append(result, r.rewriteRawURIAttrLine(sk, baseURL, r.buildKeyURL))
+			}
+			inserted = true
+		}
+	}
+	return []byte(strings.Join(result, "\n"))
+}
+
+// splicePartTags reinserts rewritten EXT-X-PART lines in a rewritten media
+// playlist immediately before the EXTINF of the segment each run preceded
+// originally, and any trailing (no-following-segment) run at the very end.
+func (r *M3U8Rewriter) splicePartTags(output []byte, partsBySegment map[int][]string, trailing []string, baseURL string) []byte {
+	if len(partsBySegment) == 0 && len(trailing) == 0 {
+		return output
+	}
+
+	lines := strings.Split(string(output), "\n")
+	result := make([]string, 0, len(lines)+len(trailing))
+	segIndex := 0
+	for _, line := range lines {
+		if strings.HasPrefix(strings.TrimSpace(line), "#EXTINF:") {
+			for _, p := range partsBySegment[segIndex] {
+				result = append(result, r.rewriteRawURIAttrLine(p, baseURL, r.buildPartURL))
+			}
+			segIndex++
+		}
+		result = append(result, line)
+	}
+	for _, p := range trailing {
+		result = append(result, r.rewriteRawURIAttrLine(p, baseURL, r.buildPartURL))
+	}
+	return []byte(strings.Join(result, "\n"))
+}
+
 func (r *M3U8Rewriter) buildKeyURL(keyURL string) string {
 	encoded := url.QueryEscape(keyURL)
 	result := r.baseURL + r.keyPrefix + "?u=" + encoded
 	if r.signParams != "" {
 		result += "&" + r.signParams
 	}
+	if r.drmSession {
+		result += "&session=1"
+	}
+	return result
+}
+
+func (r *M3U8Rewriter) buildPlaylistURL(playlistURL string) string {
+	encoded := url.QueryEscape(playlistURL)
+	result := r.baseURL + r.playlistPrefix + "?url=" + encoded
+	if r.signParams != "" {
+		result += "&" + r.signParams
+	}
 	return result
 }
 
+// IsM3U8Content reports whether content looks like an HLS playlist, without
+// fully parsing it.
 func IsM3U8Content(content []byte) bool {
-	scanner := bufio.NewScanner(strings.NewReader(string(content)))
-	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
+	for _, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimSpace(line)
 		if strings.HasPrefix(line, "#EXTM3U") {
 			return true
 		}
 		if line != "" && !strings.HasPrefix(line, "#") {
-			// If we hit non-comment content before #EXTM3U, it's probably not M3U8
 			break
 		}
 	}
 	return false
-}
\ No newline at end of file
+}
+
+// IsMasterPlaylist reports whether content is a master (variant) playlist
+// rather than a media playlist.
+func IsMasterPlaylist(content []byte) bool {
+	_, listType, err := m3u8.Decode(*newBuffer(content), true)
+	return err == nil && listType == m3u8.MASTER
+}
+
+// SelectVariant parses content as a master playlist and resolves the variant
+// matching spec to an absolute upstream URL, for handlePlay's ?variant=
+// auto-select-and-inline support. spec is one of:
+//   - "bandwidth:N"   - the variant with BANDWIDTH closest to N
+//   - "resolution:WxH" - the variant with a matching RESOLUTION attribute
+//   - a bare integer   - the variant at that 0-based index
+func SelectVariant(content []byte, baseURL, spec string) (variantURL string, found bool, err error) {
+	playlist, listType, err := m3u8.Decode(*newBuffer(content), true)
+	if err != nil {
+		return "", false, fmt.Errorf("decode m3u8: %w", err)
+	}
+	if listType != m3u8.MASTER {
+		return "", false, fmt.Errorf("not a master playlist")
+	}
+
+	master := playlist.(*m3u8.MasterPlaylist)
+	variant := matchVariant(master.Variants, spec)
+	if variant == nil || variant.URI == "" {
+		return "", false, nil
+	}
+
+	return resolveURL(variant.URI, baseURL), true, nil
+}
+
+// MediaInfo summarizes a media playlist for callers that only need to see
+// its segment URIs, not rewrite them - namely the live-segment prefetcher
+// diffing one refresh against the next.
+type MediaInfo struct {
+	SegmentURIs    []string
+	MapURI         string
+	TargetDuration float64
+	// Closed reports whether the playlist carries #EXT-X-ENDLIST, i.e. is
+	// VOD rather than live. Prefetching only makes sense for live playlists.
+	Closed bool
+}
+
+// Inspect parses content as a media playlist and resolves every segment and
+// map URI it carries against baseURL, without rewriting them to proxy URLs.
+func Inspect(content []byte, baseURL string) (MediaInfo, error) {
+	playlist, listType, err := m3u8.Decode(*newBuffer(content), true)
+	if err != nil {
+		return MediaInfo{}, fmt.Errorf("decode m3u8: %w", err)
+	}
+	if listType != m3u8.MEDIA {
+		return MediaInfo{}, fmt.Errorf("not a media playlist")
+	}
+
+	pl := playlist.(*m3u8.MediaPlaylist)
+	info := MediaInfo{TargetDuration: pl.TargetDuration, Closed: pl.Closed}
+	if pl.Map != nil && pl.Map.URI != "" {
+		info.MapURI = resolveURL(pl.Map.URI, baseURL)
+	}
+	for _, seg := range pl.Segments {
+		if seg == nil {
+			continue
+		}
+		info.SegmentURIs = append(info.SegmentURIs, resolveURL(seg.URI, baseURL))
+	}
+	return info, nil
+}
+
+func matchVariant(variants []*m3u8.Variant, spec string) *m3u8.Variant {
+	kind, value, hasKind := strings.Cut(spec, ":")
+
+	switch {
+	case hasKind && kind == "bandwidth":
+		target, err := strconv.Atoi(value)
+		if err != nil {
+			return nil
+		}
+		var best *m3u8.Variant
+		bestDiff := 0
+		for _, v := range variants {
+			if d := absInt(int(v.Bandwidth) - target); best == nil || d < bestDiff {
+				best, bestDiff = v, d
+			}
+		}
+		return best
+	case hasKind && kind == "resolution":
+		for _, v := range variants {
+			if v.Resolution == value {
+				return v
+			}
+		}
+		return nil
+	default:
+		idx, err := strconv.Atoi(spec)
+		if err != nil || idx < 0 || idx >= len(variants) {
+			return nil
+		}
+		return variants[idx]
+	}
+}
@@ -1,8 +1,11 @@
 package util
 
 import (
+	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
+	"time"
 )
 
 func IsAllowedHost(targetURL string, allowHosts []string) bool {
@@ -56,6 +59,43 @@ func GetContentType(url string) string {
 	return "application/octet-stream"
 }
 
+// ParseCacheTTL derives a cache TTL override from upstream Cache-Control/
+// Expires headers: a positive duration overrides the configured TTL, zero
+// means no opinion (use the configured TTL), and a negative duration means
+// the response must not be cached at all. max-age=0 is reported the same
+// way as no-store/no-cache (-1), not as "no opinion" (0) - an origin that
+// explicitly asks for zero caching must not fall back to the configured
+// static TTL the way an absent header does.
+func ParseCacheTTL(h http.Header) time.Duration {
+	if cc := h.Get("Cache-Control"); cc != "" {
+		for _, directive := range strings.Split(cc, ",") {
+			directive = strings.TrimSpace(directive)
+			if directive == "no-store" || directive == "no-cache" {
+				return -1
+			}
+			if strings.HasPrefix(directive, "max-age=") {
+				if secs, err := strconv.Atoi(strings.TrimPrefix(directive, "max-age=")); err == nil {
+					if secs <= 0 {
+						return -1
+					}
+					return time.Duration(secs) * time.Second
+				}
+			}
+		}
+	}
+
+	if expires := h.Get("Expires"); expires != "" {
+		if t, err := http.ParseTime(expires); err == nil {
+			if d := time.Until(t); d > 0 {
+				return d
+			}
+			return -1
+		}
+	}
+
+	return 0
+}
+
 func BuildSignParams(sign, ts string) string {
 	if sign == "" || ts == "" {
 		return ""
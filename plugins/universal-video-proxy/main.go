@@ -1,6 +1,9 @@
 package main
 
 import (
+	"bytes"
+	"crypto/tls"
+	"encoding/hex"
 	"encoding/json"
 	"flag"
 	"fmt"
@@ -8,25 +11,96 @@ import (
 	"log"
 	"net/http"
 	"net/url"
+	"os"
+	"os/signal"
 	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
+
+	"golang.org/x/sync/singleflight"
+
+	"universalvideoproxy/internal/auth"
 	"universalvideoproxy/internal/cache"
+	"universalvideoproxy/internal/coalesce"
 	"universalvideoproxy/internal/config"
+	"universalvideoproxy/internal/drm"
 	"universalvideoproxy/internal/headers"
+	"universalvideoproxy/internal/limiter"
+	"universalvideoproxy/internal/metrics"
+	"universalvideoproxy/internal/prefetch"
 	"universalvideoproxy/internal/rewrite"
+	"universalvideoproxy/internal/rewrite/dash"
 	"universalvideoproxy/internal/signer"
+	"universalvideoproxy/internal/upstream"
 	"universalvideoproxy/internal/util"
 )
 
+const streamCopyBufferSize = 32 * 1024
+
+var copyBufPool = sync.Pool{
+	New: func() interface{} {
+		buf := make([]byte, streamCopyBufferSize)
+		return &buf
+	},
+}
+
+// cappedBuffer accumulates bytes up to limit. A write that would take it past
+// limit marks it over and empties it, and every later write is dropped. Used to decide whether a streamed response is still small enough to cache
+// without holding the whole body in memory for oversized responses.
+type cappedBuffer struct {
+	buf   bytes.Buffer
+	limit int
+	over  bool
+}
+
+func (c *cappedBuffer) Write(p []byte) (int, error) {
+	if !c.over {
+		if c.buf.Len()+len(p) > c.limit {
+			c.over = true
+			c.buf.Reset()
+		} else {
+			c.buf.Write(p)
+		}
+	}
+	return len(p), nil
+}
+
+// deafWriter forwards writes to w until w returns an error, then silently
+// discards the rest. Used so a leader's copy into a coalesce.Share doesn't
+// abort - and fail every other waiter - just because the leader's own
+// client (w) went away mid-stream.
+type deafWriter struct {
+	w      io.Writer
+	broken bool
+}
+
+func (d *deafWriter) Write(p []byte) (int, error) {
+	if !d.broken {
+		if _, err := d.w.Write(p); err != nil {
+			d.broken = true
+		}
+	}
+	return len(p), nil
+}
+
 type Server struct {
 	config        *config.Config
 	signer        *signer.Signer
+	auth          auth.Authenticator
 	headerManager *headers.HeaderManager
 	m3u8Cache     *cache.Cache
 	keyCache      *cache.Cache
 	tsCache       *cache.Cache
 	httpClient    *http.Client
+	http3Client   *http.Client
+	metrics       *metrics.Counters
+	fetchGroup    singleflight.Group
+	limiter       *limiter.Limiter
+	segmentGroup  *coalesce.Group
+	prefetcher    *prefetch.Prefetcher
+	drm           *drm.Manager
 }
 
 func main() {
@@ -38,20 +112,75 @@ func main() {
 		log.Fatalf("Failed to load config: %v", err)
 	}
 
+	httpClient, err := upstream.NewClient(cfg.Upstream, cfg.AllowHosts)
+	if err != nil {
+		log.Fatalf("Failed to configure upstream client: %v", err)
+	}
+
+	var http3Client *http.Client
+	for _, proto := range cfg.Upstream.Protocols {
+		if proto == "h3" {
+			http3Client = upstream.NewH3Client(cfg.Upstream)
+			break
+		}
+	}
+
+	var authenticator auth.Authenticator
+	var certAuth *auth.CertAuth
+	if cfg.Auth.Enabled {
+		authenticator, err = auth.NewAuth(cfg.Auth.Spec)
+		if err != nil {
+			log.Fatalf("Failed to configure auth: %v", err)
+		}
+		if ca, ok := authenticator.(*auth.CertAuth); ok {
+			ca.AllowNames(cfg.Auth.AllowNames)
+			certAuth = ca
+		}
+	}
+
+	var visitorLimiter *limiter.Limiter
+	if cfg.Limits.RateLimitEnabled {
+		visitorLimiter = limiter.New(limiter.Config{
+			RPS:                   cfg.Limits.RequestsPerSecond,
+			Burst:                 cfg.Limits.Burst,
+			MaxInFlight:           cfg.Limits.MaxInFlightPerVisitor,
+			BytesPerSecond:        cfg.Limits.MaxBytesPerSecondPerIP,
+			MaxConcurrentUpstream: cfg.Limits.MaxConcurrentUpstream,
+			TrustedProxies:        cfg.Limits.TrustedProxies,
+			IdleTimeout:           time.Duration(cfg.Limits.VisitorIdleSeconds) * time.Second,
+			SweepInterval:         time.Duration(cfg.Limits.VisitorSweepSeconds) * time.Second,
+		})
+	}
+
+	var segmentPrefetcher *prefetch.Prefetcher
+	if cfg.Prefetch.Enabled {
+		segmentPrefetcher = prefetch.New(prefetch.Config{MaxConcurrentPerPlaylist: cfg.Prefetch.MaxConcurrentPerPlaylist})
+	}
+
+	var drmManager *drm.Manager
+	if cfg.DRM.Enabled {
+		drmManager = drm.NewManager(cfg.DRM.SessionTTLSeconds)
+	}
+
 	server := &Server{
 		config:        cfg,
 		signer:        signer.New(cfg.Sign.Secret, cfg.Sign.TTLSeconds, cfg.Sign.Enabled),
+		auth:          authenticator,
 		headerManager: headers.New(cfg.Headers),
-		m3u8Cache:     cache.New(cfg.Cache.M3U8.MaxEntries, cfg.Cache.M3U8.TTLSeconds, cfg.Cache.M3U8.Enabled),
-		keyCache:      cache.New(cfg.Cache.Key.MaxEntries, cfg.Cache.Key.TTLSeconds, cfg.Cache.Key.Enabled),
-		tsCache:       cache.New(cfg.Cache.TS.MaxEntries, cfg.Cache.TS.TTLSeconds, cfg.Cache.TS.Enabled),
-		httpClient: &http.Client{
-			Timeout: time.Duration(cfg.Upstream.TimeoutMs) * time.Millisecond,
-		},
+		m3u8Cache:     cache.New(cfg.Cache.M3U8.MaxEntries, cfg.Cache.M3U8.TTLSeconds, cfg.Cache.M3U8.Enabled, cfg.Cache.M3U8.NegativeTTLSeconds),
+		keyCache:      cache.New(cfg.Cache.Key.MaxEntries, cfg.Cache.Key.TTLSeconds, cfg.Cache.Key.Enabled, cfg.Cache.Key.NegativeTTLSeconds),
+		tsCache:       cache.New(cfg.Cache.TS.MaxEntries, cfg.Cache.TS.TTLSeconds, cfg.Cache.TS.Enabled, cfg.Cache.TS.NegativeTTLSeconds),
+		httpClient:    httpClient,
+		http3Client:   http3Client,
+		metrics:       &metrics.Counters{},
+		limiter:       visitorLimiter,
+		segmentGroup:  coalesce.NewGroup(),
+		prefetcher:    segmentPrefetcher,
+		drm:           drmManager,
 	}
 
 	mux := http.NewServeMux()
-	
+
 	// Setup routes
 	mux.HandleFunc("/health", server.handleHealth)
 	mux.HandleFunc("/play", server.handlePlay)
@@ -59,18 +188,65 @@ func main() {
 	mux.HandleFunc("/key", server.handleKey)
 	mux.HandleFunc("/raw", server.handleRaw)
 	mux.HandleFunc("/sign", server.handleSign)
+	mux.HandleFunc("/dashseg/", server.handleDashSegment)
+	mux.HandleFunc("/metrics", server.handleMetrics)
+
+	// CORS, then rate limiting, then routing
+	handler := server.corsMiddleware(server.rateLimitMiddleware(mux))
 
-	// CORS middleware
-	handler := server.corsMiddleware(mux)
+	go server.watchForReload(*configPath)
 
 	log.Printf("Universal Video Proxy starting on %s", cfg.Listen)
 	log.Printf("Signing enabled: %v", cfg.Sign.Enabled)
-	
+	log.Printf("Auth enabled: %v", cfg.Auth.Enabled)
+	log.Printf("DRM re-encryption enabled: %v", cfg.DRM.Enabled)
+
+	if certAuth != nil {
+		// "cert" auth is only meaningful over TLS - r.TLS is nil on a plain
+		// HTTP listener, so ClientCAPool() and CertAuth.Authenticate would
+		// never see a client certificate to verify.
+		if cfg.Auth.TLSCertFile == "" || cfg.Auth.TLSKeyFile == "" {
+			log.Fatalf("auth.spec is \"cert:...\" but auth.tlsCertFile/auth.tlsKeyFile are not set")
+		}
+		srv := &http.Server{
+			Addr:    cfg.Listen,
+			Handler: handler,
+			TLSConfig: &tls.Config{
+				ClientCAs:  certAuth.ClientCAPool(),
+				ClientAuth: tls.RequireAndVerifyClientCert,
+			},
+		}
+		if err := srv.ListenAndServeTLS(cfg.Auth.TLSCertFile, cfg.Auth.TLSKeyFile); err != nil {
+			log.Fatalf("Server failed: %v", err)
+		}
+		return
+	}
+
 	if err := http.ListenAndServe(cfg.Listen, handler); err != nil {
 		log.Fatalf("Server failed: %v", err)
 	}
 }
 
+// watchForReload re-reads configPath's Headers rules into the running
+// HeaderManager on SIGHUP, so an operator can roll out a new header ruleset
+// (strip-lists, response rewrites, cookie-jar hosts) without a restart. The
+// rest of the config is intentionally left alone - other settings (signing,
+// auth, limits) take effect on the next full restart.
+func (s *Server) watchForReload(configPath string) {
+	reloadCh := make(chan os.Signal, 1)
+	signal.Notify(reloadCh, syscall.SIGHUP)
+
+	for range reloadCh {
+		cfg, err := config.LoadConfig(configPath)
+		if err != nil {
+			log.Printf("Reload: failed to read %s: %v", configPath, err)
+			continue
+		}
+		s.headerManager.Reload(cfg.Headers)
+		log.Printf("Reload: applied %d header rule(s) from %s", len(cfg.Headers), configPath)
+	}
+}
+
 func (s *Server) corsMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Set CORS headers
@@ -96,6 +272,33 @@ func (s *Server) corsMiddleware(next http.Handler) http.Handler {
 	})
 }
 
+// rateLimitMiddleware enforces per-visitor RPS/in-flight/byte-rate caps plus
+// the global MaxConcurrentUpstream cap, ahead of routing. /health and
+// /metrics are exempt so monitoring isn't subject to client throttling.
+func (s *Server) rateLimitMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.limiter == nil || r.URL.Path == "/health" || r.URL.Path == "/metrics" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		key := s.limiter.ClientKey(r)
+		result, err := s.limiter.Allow(key)
+		if err != nil {
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, err.Error(), http.StatusTooManyRequests)
+			return
+		}
+		defer result.Release()
+
+		if result.ByteLimiter != nil {
+			w = limiter.NewThrottledWriter(w, result.ByteLimiter)
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
 func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
@@ -104,6 +307,36 @@ func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.Write([]byte(s.metrics.Render()))
+}
+
+// checkAccess combines the optional front-door Authenticator with the
+// existing per-URL HMAC signer. With no Authenticator configured it falls
+// back to today's signer-only behavior (a no-op when signing is disabled).
+// With one configured, cfg.Auth.Combine selects whether either check
+// suffices or both are required.
+func (s *Server) checkAccess(r *http.Request, targetURL, sign, ts string) error {
+	signErr := s.signer.Verify(targetURL, sign, ts)
+	if s.auth == nil {
+		return signErr
+	}
+
+	authErr := s.auth.Authenticate(r)
+	if auth.Combine(s.config.Auth.Combine) == auth.CombineEither {
+		if authErr == nil || signErr == nil {
+			return nil
+		}
+		return fmt.Errorf("auth failed (%v) and signature failed (%v)", authErr, signErr)
+	}
+
+	if authErr != nil {
+		return authErr
+	}
+	return signErr
+}
+
 func (s *Server) handleSign(w http.ResponseWriter, r *http.Request) {
 	if !s.signer.IsEnabled() {
 		http.Error(w, "Signing is disabled", http.StatusForbidden)
@@ -167,38 +400,87 @@ func (s *Server) handlePlay(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Verify signature if enabled
+	// Verify signature and/or front-door auth, per Auth.Combine
 	sign := r.URL.Query().Get("sign")
 	ts := r.URL.Query().Get("ts")
-	if err := s.signer.Verify(targetURL, sign, ts); err != nil {
-		http.Error(w, "Signature verification failed: "+err.Error(), http.StatusUnauthorized)
+	if err := s.checkAccess(r, targetURL, sign, ts); err != nil {
+		http.Error(w, "Access denied: "+err.Error(), http.StatusUnauthorized)
 		return
 	}
 
 	// Check cache for M3U8
 	cacheKey := targetURL
 	if cached, found := s.m3u8Cache.Get(cacheKey); found {
+		s.metrics.IncCacheHit()
 		w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
 		w.Header().Set("Cache-Control", "no-cache")
+		s.headerManager.RewriteResponseHeaders(targetURL, w.Header())
 		w.Write(cached)
 		return
 	}
+	s.metrics.IncCacheMiss()
 
-	// Fetch content
-	content, contentType, err := s.fetchContent(targetURL)
+	if s.m3u8Cache.IsNegative(cacheKey) {
+		s.metrics.IncNegativeCacheHit()
+		http.Error(w, "Failed to fetch content", http.StatusBadGateway)
+		return
+	}
+
+	// Fetch content, coalescing concurrent requests for the same URL into a
+	// single upstream round-trip.
+	content, contentType, ttl, err := s.coalescedFetch(targetURL)
 	if err != nil {
 		log.Printf("Failed to fetch %s: %v", targetURL, err)
+		s.m3u8Cache.SetNegative(cacheKey)
 		http.Error(w, "Failed to fetch content", http.StatusBadGateway)
 		return
 	}
 
+	// Check if it's a DASH manifest and should be rewritten
+	isDASHByExt := false
+	if parsed, err := url.Parse(targetURL); err == nil {
+		isDASHByExt = strings.HasSuffix(parsed.Path, ".mpd")
+	}
+	if s.config.Rewrite.EnableM3U8 && (strings.Contains(contentType, "application/dash+xml") || isDASHByExt) && dash.IsDASHContent(content) {
+		rewritten, err := dash.Rewrite(content, targetURL, "/dashseg")
+		if err != nil {
+			log.Printf("Failed to rewrite MPD: %v", err)
+			http.Error(w, "Failed to process manifest", http.StatusInternalServerError)
+			return
+		}
+
+		if ttl >= 0 {
+			s.m3u8Cache.SetWithTTL(cacheKey, rewritten, ttl)
+		}
+
+		w.Header().Set("Content-Type", "application/dash+xml")
+		w.Header().Set("Cache-Control", "no-cache")
+		s.headerManager.RewriteResponseHeaders(targetURL, w.Header())
+		w.Write(rewritten)
+		return
+	}
+
 	// Check if it's M3U8 and should be rewritten
 	if s.config.Rewrite.EnableM3U8 && rewrite.IsM3U8Content(content) {
-		// Build sign params for rewritten URLs
 		signParams := util.BuildSignParams(sign, ts)
-		
-		// Rewrite the playlist
-		rewriter := rewrite.NewM3U8Rewriter("", "/seg", "/key", signParams)
+
+		// ?variant= auto-selects one variant out of a master playlist and
+		// inlines its media playlist directly, so a client that doesn't
+		// speak ABR just gets a ready-to-play media playlist.
+		if variantSpec := r.URL.Query().Get("variant"); variantSpec != "" && rewrite.IsMasterPlaylist(content) {
+			s.servePlaylistVariant(w, content, targetURL, variantSpec, signParams)
+			return
+		}
+
+		if s.prefetcher != nil && !rewrite.IsMasterPlaylist(content) {
+			s.triggerPrefetch(targetURL, content)
+		}
+
+		rewriter := rewrite.NewM3U8Rewriter("", "/seg", "/key", "/play", signParams, rewrite.VariantPolicy{
+			KeepAll:       s.config.Rewrite.KeepAllVariants,
+			Select:        s.config.Rewrite.VariantSelect,
+			TargetBitrate: s.config.Rewrite.TargetBitrate,
+		}, s.config.DRM.Enabled)
 		rewritten, err := rewriter.Rewrite(content, targetURL)
 		if err != nil {
 			log.Printf("Failed to rewrite M3U8: %v", err)
@@ -206,11 +488,14 @@ func (s *Server) handlePlay(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		// Cache the rewritten content
-		s.m3u8Cache.Set(cacheKey, rewritten)
+		// Cache the rewritten content, honoring an upstream TTL override
+		if ttl >= 0 {
+			s.m3u8Cache.SetWithTTL(cacheKey, rewritten, ttl)
+		}
 
 		w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
 		w.Header().Set("Cache-Control", "no-cache")
+		s.headerManager.RewriteResponseHeaders(targetURL, w.Header())
 		w.Write(rewritten)
 		return
 	}
@@ -219,6 +504,57 @@ func (s *Server) handlePlay(w http.ResponseWriter, r *http.Request) {
 	s.streamContent(w, r, targetURL, content, contentType)
 }
 
+// servePlaylistVariant resolves variantSpec against masterContent, fetches
+// that variant's media playlist, and writes it rewritten in place of the
+// master - the ?variant= auto-select-and-inline path.
+func (s *Server) servePlaylistVariant(w http.ResponseWriter, masterContent []byte, masterURL, variantSpec, signParams string) {
+	variantURL, found, err := rewrite.SelectVariant(masterContent, masterURL, variantSpec)
+	if err != nil {
+		log.Printf("Failed to select variant %q of %s: %v", variantSpec, masterURL, err)
+		http.Error(w, "Failed to process playlist", http.StatusInternalServerError)
+		return
+	}
+	if !found {
+		http.Error(w, "Requested variant not found", http.StatusNotFound)
+		return
+	}
+
+	cacheKey := masterURL + "|variant=" + variantSpec
+	if cached, found := s.m3u8Cache.Get(cacheKey); found {
+		s.metrics.IncCacheHit()
+		w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+		w.Header().Set("Cache-Control", "no-cache")
+		s.headerManager.RewriteResponseHeaders(masterURL, w.Header())
+		w.Write(cached)
+		return
+	}
+	s.metrics.IncCacheMiss()
+
+	variantContent, _, ttl, err := s.coalescedFetch(variantURL)
+	if err != nil {
+		log.Printf("Failed to fetch variant %s: %v", variantURL, err)
+		http.Error(w, "Failed to fetch variant", http.StatusBadGateway)
+		return
+	}
+
+	rewriter := rewrite.NewM3U8Rewriter("", "/seg", "/key", "/play", signParams, rewrite.VariantPolicy{KeepAll: true}, s.config.DRM.Enabled)
+	rewritten, err := rewriter.Rewrite(variantContent, variantURL)
+	if err != nil {
+		log.Printf("Failed to rewrite variant playlist %s: %v", variantURL, err)
+		http.Error(w, "Failed to process playlist", http.StatusInternalServerError)
+		return
+	}
+
+	if ttl >= 0 {
+		s.m3u8Cache.SetWithTTL(cacheKey, rewritten, ttl)
+	}
+
+	w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+	w.Header().Set("Cache-Control", "no-cache")
+	s.headerManager.RewriteResponseHeaders(variantURL, w.Header())
+	w.Write(rewritten)
+}
+
 func (s *Server) handleSegment(w http.ResponseWriter, r *http.Request) {
 	targetURL := r.URL.Query().Get("u")
 	if targetURL == "" {
@@ -237,37 +573,65 @@ func (s *Server) handleSegment(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Verify signature if enabled
+	// Verify signature and/or front-door auth, per Auth.Combine
 	sign := r.URL.Query().Get("sign")
 	ts := r.URL.Query().Get("ts")
-	if err := s.signer.Verify(targetURL, sign, ts); err != nil {
-		http.Error(w, "Signature verification failed: "+err.Error(), http.StatusUnauthorized)
+	if err := s.checkAccess(r, targetURL, sign, ts); err != nil {
+		http.Error(w, "Access denied: "+err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	// DRM re-encryption: the M3U8 rewriter tags an encrypted segment's URL
+	// with the upstream key it needs decrypted with. Its re-encrypted bytes
+	// are unique to this signed session, so this path bypasses tsCache and
+	// segmentGroup coalescing entirely - passthrough otherwise.
+	if s.drm != nil {
+		if keyParam := r.URL.Query().Get("key"); keyParam != "" {
+			if err := s.streamReencryptedSegment(w, targetURL, keyParam, r.URL.Query().Get("iv"), sign, ts); err != nil {
+				log.Printf("Failed to re-encrypt segment %s: %v", targetURL, err)
+				http.Error(w, "Failed to fetch segment", http.StatusBadGateway)
+			}
+			return
+		}
+	}
+
+	// EXT-X-BYTERANGE segments address a sub-range of an upstream file (often
+	// shared across several playlist segments), so the whole file is
+	// fetched/cached once and the requested range sliced out of it, reusing
+	// the same range-slicing handleRangeRequest uses for client Range requests.
+	if rangeParam := r.URL.Query().Get("range"); rangeParam != "" {
+		if err := s.streamSegmentRange(w, r, targetURL, rangeParam); err != nil {
+			log.Printf("Failed to stream byte-range segment %s: %v", targetURL, err)
+			http.Error(w, "Failed to fetch segment", http.StatusBadGateway)
+		}
 		return
 	}
 
-	// Check TS cache
+	// Cached segments are small by definition (they passed the cacheable-size
+	// check below), so serve them from memory with the existing range slicing.
 	cacheKey := targetURL
 	if cached, found := s.tsCache.Get(cacheKey); found {
-		w.Header().Set("Content-Type", util.GetContentType(targetURL))
-		w.Header().Set("Accept-Ranges", "bytes")
-		w.Write(cached)
+		s.metrics.IncCacheHit()
+		s.streamContent(w, r, targetURL, cached, util.GetContentType(targetURL))
 		return
 	}
+	s.metrics.IncCacheMiss()
 
-	// Fetch and stream segment
-	content, contentType, err := s.fetchContent(targetURL)
-	if err != nil {
-		log.Printf("Failed to fetch segment %s: %v", targetURL, err)
+	if s.tsCache.IsNegative(cacheKey) {
+		s.metrics.IncNegativeCacheHit()
 		http.Error(w, "Failed to fetch segment", http.StatusBadGateway)
 		return
 	}
 
-	// Cache if enabled and not too large
-	if s.tsCache.IsEnabled() && len(content) < 1024*1024 { // Cache only if < 1MB
-		s.tsCache.Set(cacheKey, content)
+	// Large segments stream straight through to bound memory use; only
+	// responses under MaxCacheableBytes get buffered into tsCache. Full-object
+	// requests additionally coalesce concurrent fetches of the same URL.
+	if err := s.streamSegment(w, r, targetURL); err != nil {
+		s.tsCache.SetNegative(cacheKey)
+		log.Printf("Failed to stream segment %s: %v", targetURL, err)
+		http.Error(w, "Failed to fetch segment", http.StatusBadGateway)
+		return
 	}
-
-	s.streamContent(w, r, targetURL, content, contentType)
 }
 
 func (s *Server) handleKey(w http.ResponseWriter, r *http.Request) {
@@ -288,37 +652,74 @@ func (s *Server) handleKey(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Verify signature if enabled
+	// Verify signature and/or front-door auth, per Auth.Combine
 	sign := r.URL.Query().Get("sign")
 	ts := r.URL.Query().Get("ts")
-	if err := s.signer.Verify(targetURL, sign, ts); err != nil {
-		http.Error(w, "Signature verification failed: "+err.Error(), http.StatusUnauthorized)
+	if err := s.checkAccess(r, targetURL, sign, ts); err != nil {
+		http.Error(w, "Access denied: "+err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	// DRM re-encryption: the M3U8 rewriter tags session-scoped key URLs with
+	// session=1, so the client's signed session (sign+ts) gets its own
+	// proxy-generated key instead of the real upstream one.
+	if s.drm != nil && r.URL.Query().Get("session") == "1" {
+		s.serveSessionKey(w, targetURL, sign, ts)
 		return
 	}
 
 	// Check key cache
 	cacheKey := targetURL
 	if cached, found := s.keyCache.Get(cacheKey); found {
+		s.metrics.IncCacheHit()
 		w.Header().Set("Content-Type", "application/octet-stream")
+		s.headerManager.RewriteResponseHeaders(targetURL, w.Header())
 		w.Write(cached)
 		return
 	}
+	s.metrics.IncCacheMiss()
 
-	// Fetch key
-	content, _, err := s.fetchContent(targetURL)
+	if s.keyCache.IsNegative(cacheKey) {
+		s.metrics.IncNegativeCacheHit()
+		http.Error(w, "Failed to fetch key", http.StatusBadGateway)
+		return
+	}
+
+	// Fetch the key, coalescing concurrent requests for the same URL.
+	content, _, ttl, err := s.coalescedFetch(targetURL)
 	if err != nil {
 		log.Printf("Failed to fetch key %s: %v", targetURL, err)
+		s.keyCache.SetNegative(cacheKey)
 		http.Error(w, "Failed to fetch key", http.StatusBadGateway)
 		return
 	}
 
-	// Cache the key
-	s.keyCache.Set(cacheKey, content)
+	// Cache the key, honoring an upstream TTL override
+	if ttl >= 0 {
+		s.keyCache.SetWithTTL(cacheKey, content, ttl)
+	}
 
 	w.Header().Set("Content-Type", "application/octet-stream")
+	s.headerManager.RewriteResponseHeaders(targetURL, w.Header())
 	w.Write(content)
 }
 
+// serveSessionKey returns the caller's session-scoped AES-128 key instead of
+// the real key at upstreamKeyURL, generating it the first time a client with
+// this sign+ts fetches it. The session ID ties the key to the exact signed
+// request that was already verified by checkAccess above.
+func (s *Server) serveSessionKey(w http.ResponseWriter, upstreamKeyURL, sign, ts string) {
+	session, err := s.drm.SessionFor(sign+"|"+ts, upstreamKeyURL)
+	if err != nil {
+		log.Printf("Failed to create DRM session for %s: %v", upstreamKeyURL, err)
+		http.Error(w, "Failed to issue key", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Write(session.Key[:])
+}
+
 func (s *Server) handleRaw(w http.ResponseWriter, r *http.Request) {
 	targetURL := r.URL.Query().Get("url")
 	if targetURL == "" {
@@ -337,55 +738,117 @@ func (s *Server) handleRaw(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Fetch and stream raw content (no caching, no rewriting)
-	content, contentType, err := s.fetchContent(targetURL)
-	if err != nil {
-		log.Printf("Failed to fetch raw %s: %v", targetURL, err)
+	// /raw isn't URL-signed, so it relies solely on front-door auth when configured.
+	if s.auth != nil {
+		if err := s.auth.Authenticate(r); err != nil {
+			http.Error(w, "Access denied: "+err.Error(), http.StatusUnauthorized)
+			return
+		}
+	}
+
+	// Stream raw content straight through (no caching, no rewriting), with
+	// the client's own headers forwarded verbatim (minus strip-listed ones).
+	if err := s.streamUpstream(w, r, targetURL, nil, true); err != nil {
+		log.Printf("Failed to stream raw %s: %v", targetURL, err)
 		http.Error(w, "Failed to fetch content", http.StatusBadGateway)
 		return
 	}
+}
 
-	s.streamContent(w, r, targetURL, content, contentType)
+// handleDashSegment resolves the proxy BaseURL scheme dash.BuildProxyURL
+// produces: the path segment right after /dashseg/ is the URL-path-escaped
+// DASH base, and whatever the player appended after it (the SegmentTemplate-
+// resolved relative path, $Number$/$Time$ substituted) is resolved against
+// that base to recover the real upstream URL.
+func (s *Server) handleDashSegment(w http.ResponseWriter, r *http.Request) {
+	rest := strings.TrimPrefix(r.URL.EscapedPath(), "/dashseg/")
+	firstSlash := strings.Index(rest, "/")
+	if firstSlash < 0 {
+		http.Error(w, "Invalid DASH segment path", http.StatusBadRequest)
+		return
+	}
+
+	encodedBase, relative := rest[:firstSlash], rest[firstSlash+1:]
+	base, err := url.PathUnescape(encodedBase)
+	if err != nil {
+		http.Error(w, "Invalid DASH base URL", http.StatusBadRequest)
+		return
+	}
+
+	baseURL, err := url.Parse(base)
+	if err != nil {
+		http.Error(w, "Invalid DASH base URL", http.StatusBadRequest)
+		return
+	}
+
+	targetURL := base
+	if relative != "" {
+		resolved, err := baseURL.Parse(relative)
+		if err != nil {
+			http.Error(w, "Invalid DASH segment reference", http.StatusBadRequest)
+			return
+		}
+		targetURL = resolved.String()
+	}
+
+	if !util.IsAllowedHost(targetURL, s.config.AllowHosts) {
+		http.Error(w, "Host not allowed", http.StatusForbidden)
+		return
+	}
+
+	if err := s.streamUpstream(w, r, targetURL, s.tsCache, false); err != nil {
+		log.Printf("Failed to stream DASH segment %s: %v", targetURL, err)
+		http.Error(w, "Failed to fetch segment", http.StatusBadGateway)
+		return
+	}
 }
 
-func (s *Server) fetchContent(targetURL string) ([]byte, string, error) {
+// fetchContent buffers the full response body, which is appropriate for
+// playlists and keys. A response whose Content-Length exceeds MaxPlaylistKB
+// is refused; one without a Content-Length is read in full. It returns a TTL
+// override derived from the upstream Cache-Control/Expires headers: 0 means
+// "use the configured TTL", negative means "do not cache".
+func (s *Server) fetchContent(targetURL string) ([]byte, string, time.Duration, error) {
 	req, err := http.NewRequest("GET", targetURL, nil)
 	if err != nil {
-		return nil, "", err
+		return nil, "", 0, err
 	}
+	s.headerManager.ApplyHostRewrite(targetURL, req)
 
 	// Process headers
 	originalHeaders := map[string]string{
 		"User-Agent": "Mozilla/5.0 (compatible; UniversalVideoProxy/1.0)",
 	}
 	processedHeaders := s.headerManager.ProcessHeaders(targetURL, originalHeaders)
-	
+
 	for k, v := range processedHeaders {
 		req.Header.Set(k, v)
 	}
 
-	resp, err := s.httpClient.Do(req)
+	resp, err := s.doUpstream(req)
 	if err != nil {
-		return nil, "", err
+		return nil, "", 0, err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, "", fmt.Errorf("upstream returned %d", resp.StatusCode)
+		return nil, "", 0, fmt.Errorf("upstream returned %d", resp.StatusCode)
 	}
 
+	s.headerManager.CaptureCookies(targetURL, resp.Header)
+
 	// Check content length limits for playlists
 	if contentLength := resp.Header.Get("Content-Length"); contentLength != "" {
 		if length, err := strconv.ParseInt(contentLength, 10, 64); err == nil {
 			if length > int64(s.config.Limits.MaxPlaylistKB*1024) {
-				return nil, "", fmt.Errorf("content too large: %d bytes", length)
+				return nil, "", 0, fmt.Errorf("content too large: %d bytes", length)
 			}
 		}
 	}
 
 	content, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return nil, "", err
+		return nil, "", 0, err
 	}
 
 	contentType := resp.Header.Get("Content-Type")
@@ -393,7 +856,405 @@ func (s *Server) fetchContent(targetURL string) ([]byte, string, error) {
 		contentType = util.GetContentType(targetURL)
 	}
 
-	return content, contentType, nil
+	return content, contentType, util.ParseCacheTTL(resp.Header), nil
+}
+
+// coalescedFetch wraps fetchContent in a singleflight group keyed by
+// targetURL so N concurrent requests for the same hot m3u8/key URL share one
+// upstream round-trip instead of causing a thundering herd.
+func (s *Server) coalescedFetch(targetURL string) ([]byte, string, time.Duration, error) {
+	type result struct {
+		data        []byte
+		contentType string
+		ttl         time.Duration
+	}
+
+	v, err, shared := s.fetchGroup.Do(targetURL, func() (interface{}, error) {
+		data, contentType, ttl, err := s.fetchContent(targetURL)
+		if err != nil {
+			return nil, err
+		}
+		return result{data, contentType, ttl}, nil
+	})
+	if shared {
+		s.metrics.IncSingleflightShared()
+	}
+	if err != nil {
+		return nil, "", 0, err
+	}
+
+	r := v.(result)
+	return r.data, r.contentType, r.ttl, nil
+}
+
+// doUpstream picks H2 or H3 for req per Upstream.Protocols (matched on
+// req.URL's hostname) and executes it, falling back to the H2 client and
+// retrying once if an H3 attempt fails - QUIC is blocked on plenty of
+// networks that happily pass TCP.
+func (s *Server) doUpstream(req *http.Request) (*http.Response, error) {
+	proto := upstream.SelectProtocol(req.URL.Hostname(), s.config.Upstream.Protocols)
+
+	if proto == "h3" && s.http3Client != nil {
+		resp, err := s.http3Client.Do(req)
+		if err == nil {
+			s.metrics.IncUpstreamProtocol("h3")
+			return resp, nil
+		}
+		s.metrics.IncUpstreamH3Fallback()
+		proto = "h2"
+	}
+
+	resp, err := s.httpClient.Do(req)
+	if err == nil {
+		s.metrics.IncUpstreamProtocol(proto)
+	}
+	return resp, err
+}
+
+// streamUpstream forwards the client's Range/If-Range headers to targetURL
+// and pipes the upstream body directly to w with a bounded buffer, rather
+// than reading the whole response into memory first. If c is non-nil and the
+// response turns out to fit under MaxCacheableBytes, it is also cached. When
+// forwardClientHeaders is set (the /raw passthrough path), the client's own
+// request headers are forwarded verbatim, minus hop-by-hop headers and
+// anything a per-host StripRequestHeaders rule removes.
+func (s *Server) streamUpstream(w http.ResponseWriter, r *http.Request, targetURL string, c *cache.Cache, forwardClientHeaders bool) error {
+	req, err := http.NewRequest("GET", targetURL, nil)
+	if err != nil {
+		return err
+	}
+	s.headerManager.ApplyHostRewrite(targetURL, req)
+
+	if forwardClientHeaders {
+		for k, v := range r.Header {
+			if !headers.IsHopByHop(k) {
+				req.Header[k] = v
+			}
+		}
+		s.headerManager.StripRequestHeaders(targetURL, req.Header)
+	}
+
+	originalHeaders := map[string]string{
+		"User-Agent": "Mozilla/5.0 (compatible; UniversalVideoProxy/1.0)",
+	}
+	for k, v := range s.headerManager.ProcessHeaders(targetURL, originalHeaders) {
+		req.Header.Set(k, v)
+	}
+
+	rangeHeader := r.Header.Get("Range")
+	if rangeHeader != "" {
+		req.Header.Set("Range", rangeHeader)
+	}
+	if ifRange := r.Header.Get("If-Range"); ifRange != "" {
+		req.Header.Set("If-Range", ifRange)
+	}
+
+	resp, err := s.doUpstream(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+		return fmt.Errorf("upstream returned %d", resp.StatusCode)
+	}
+
+	s.headerManager.CaptureCookies(targetURL, resp.Header)
+
+	for _, h := range []string{"Content-Type", "Content-Length", "Content-Range", "ETag", "Last-Modified"} {
+		if v := resp.Header.Get(h); v != "" {
+			w.Header().Set(h, v)
+		}
+	}
+	if w.Header().Get("Content-Type") == "" {
+		w.Header().Set("Content-Type", util.GetContentType(targetURL))
+	}
+	w.Header().Set("Accept-Ranges", "bytes")
+	s.headerManager.RewriteResponseHeaders(targetURL, w.Header())
+	w.WriteHeader(resp.StatusCode)
+
+	bufPtr := copyBufPool.Get().(*[]byte)
+	defer copyBufPool.Put(bufPtr)
+
+	// Only cacheable when the whole resource (not a range) was fetched.
+	if c != nil && c.IsEnabled() && rangeHeader == "" {
+		capped := &cappedBuffer{limit: s.config.Limits.MaxCacheableBytes}
+		_, err := io.CopyBuffer(io.MultiWriter(w, capped), resp.Body, *bufPtr)
+		if err == nil && !capped.over {
+			c.Set(targetURL, capped.buf.Bytes())
+		}
+		return err
+	}
+
+	_, err = io.CopyBuffer(w, resp.Body, *bufPtr)
+	return err
+}
+
+// streamSegment serves a TS segment, coalescing concurrent full-object
+// requests for the same targetURL into a single upstream fetch whose bytes
+// are fanned out to every waiter as they arrive. Range requests target
+// specific byte offsets and fall back to the uncoalesced streamUpstream path.
+func (s *Server) streamSegment(w http.ResponseWriter, r *http.Request, targetURL string) error {
+	if r.Header.Get("Range") != "" {
+		return s.streamUpstream(w, r, targetURL, s.tsCache, false)
+	}
+
+	share, isLeader := s.segmentGroup.Join(targetURL)
+	if isLeader {
+		defer s.segmentGroup.Leave(targetURL)
+		return s.fetchSegmentIntoShare(w, r, targetURL, share)
+	}
+
+	status, header, err := share.WaitHeader()
+	if err != nil {
+		return err
+	}
+	for k, v := range header {
+		w.Header()[k] = v
+	}
+	w.WriteHeader(status)
+	return share.CopyTo(w)
+}
+
+// fetchSegmentIntoShare performs the upstream fetch for a coalesced segment
+// request, writing bytes to both the leader's own client and share at the
+// same time so followers see data as soon as the leader does.
+func (s *Server) fetchSegmentIntoShare(w http.ResponseWriter, r *http.Request, targetURL string, share *coalesce.Share) error {
+	req, err := http.NewRequest("GET", targetURL, nil)
+	if err != nil {
+		share.Fail(err)
+		return err
+	}
+	s.headerManager.ApplyHostRewrite(targetURL, req)
+
+	originalHeaders := map[string]string{
+		"User-Agent": "Mozilla/5.0 (compatible; UniversalVideoProxy/1.0)",
+	}
+	for k, v := range s.headerManager.ProcessHeaders(targetURL, originalHeaders) {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := s.doUpstream(req)
+	if err != nil {
+		share.Fail(err)
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		err := fmt.Errorf("upstream returned %d", resp.StatusCode)
+		share.Fail(err)
+		return err
+	}
+
+	s.headerManager.CaptureCookies(targetURL, resp.Header)
+
+	header := http.Header{}
+	for _, h := range []string{"Content-Type", "Content-Length", "ETag", "Last-Modified"} {
+		if v := resp.Header.Get(h); v != "" {
+			header.Set(h, v)
+		}
+	}
+	if header.Get("Content-Type") == "" {
+		header.Set("Content-Type", util.GetContentType(targetURL))
+	}
+	header.Set("Accept-Ranges", "bytes")
+	s.headerManager.RewriteResponseHeaders(targetURL, header)
+	share.SetHeader(resp.StatusCode, header)
+
+	for k, v := range header {
+		w.Header()[k] = v
+	}
+	w.WriteHeader(resp.StatusCode)
+
+	bufPtr := copyBufPool.Get().(*[]byte)
+	defer copyBufPool.Put(bufPtr)
+
+	capped := &cappedBuffer{limit: s.config.Limits.MaxCacheableBytes}
+	leaderWriter := &deafWriter{w: w}
+	_, copyErr := io.CopyBuffer(io.MultiWriter(leaderWriter, share, capped), resp.Body, *bufPtr)
+	if copyErr != nil {
+		share.Fail(copyErr)
+		return copyErr
+	}
+
+	share.Finish()
+	if !capped.over && s.tsCache.IsEnabled() {
+		s.tsCache.Set(targetURL, capped.buf.Bytes())
+	}
+	return nil
+}
+
+// triggerPrefetch diffs a freshly-fetched live media playlist against the
+// last refresh seen for targetURL and eagerly warms tsCache with any
+// newly-appeared segment/map URIs, pacing the fetches across
+// EXT-X-TARGETDURATION. VOD playlists (EXT-X-ENDLIST present) are skipped,
+// since there's nothing "upcoming" left to warm.
+func (s *Server) triggerPrefetch(targetURL string, content []byte) {
+	info, err := rewrite.Inspect(content, targetURL)
+	if err != nil || info.Closed {
+		return
+	}
+
+	uris := info.SegmentURIs
+	if info.MapURI != "" {
+		uris = append([]string{info.MapURI}, uris...)
+	}
+
+	s.prefetcher.OnRefresh(targetURL, uris, info.TargetDuration, s.fetchSegmentBytes, s.tsCache)
+}
+
+// fetchSegmentBytes performs a plain upstream GET of targetURL capped at
+// MaxCacheableBytes, for the prefetcher and the byte-range segment path - both
+// have no client ResponseWriter to stream into, so neither can reuse
+// streamUpstream/fetchSegmentIntoShare.
+func (s *Server) fetchSegmentBytes(targetURL string) ([]byte, error) {
+	return s.fetchBytesCapped(targetURL, s.config.Limits.MaxCacheableBytes)
+}
+
+// fetchBytesCapped performs a plain upstream GET of targetURL, buffering up
+// to limit bytes in memory before giving up.
+func (s *Server) fetchBytesCapped(targetURL string, limit int) ([]byte, error) {
+	req, err := http.NewRequest("GET", targetURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	s.headerManager.ApplyHostRewrite(targetURL, req)
+
+	originalHeaders := map[string]string{
+		"User-Agent": "Mozilla/5.0 (compatible; UniversalVideoProxy/1.0)",
+	}
+	for k, v := range s.headerManager.ProcessHeaders(targetURL, originalHeaders) {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := s.doUpstream(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("upstream returned %d", resp.StatusCode)
+	}
+
+	bufPtr := copyBufPool.Get().(*[]byte)
+	defer copyBufPool.Put(bufPtr)
+
+	capped := &cappedBuffer{limit: limit}
+	if _, err := io.CopyBuffer(capped, resp.Body, *bufPtr); err != nil {
+		return nil, err
+	}
+	if capped.over {
+		return nil, fmt.Errorf("content exceeds %d-byte limit", limit)
+	}
+	return capped.buf.Bytes(), nil
+}
+
+// parseSegmentRange parses the rewriter's "&range=offset-length" query
+// value (the EXT-X-BYTERANGE attributes it was built from).
+func parseSegmentRange(rangeParam string) (offset, length int64, ok bool) {
+	parts := strings.SplitN(rangeParam, "-", 2)
+	if len(parts) != 2 {
+		return 0, 0, false
+	}
+	offset, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil || offset < 0 {
+		return 0, 0, false
+	}
+	length, err = strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || length <= 0 {
+		return 0, 0, false
+	}
+	return offset, length, true
+}
+
+// streamSegmentRange serves the sub-range of targetURL named by rangeParam.
+// Since a byte-range segment only ever addresses part of the upstream file,
+// the whole file is fetched/cached (keyed by targetURL, same as any other
+// segment) and the requested range is sliced out of it in memory.
+func (s *Server) streamSegmentRange(w http.ResponseWriter, r *http.Request, targetURL, rangeParam string) error {
+	offset, length, ok := parseSegmentRange(rangeParam)
+	if !ok {
+		return fmt.Errorf("invalid range parameter %q", rangeParam)
+	}
+
+	cacheKey := targetURL
+	content, found := s.tsCache.Get(cacheKey)
+	if !found {
+		var err error
+		content, err = s.fetchSegmentBytes(targetURL)
+		if err != nil {
+			return err
+		}
+		if s.tsCache.IsEnabled() {
+			s.tsCache.Set(cacheKey, content)
+		}
+	}
+
+	if offset+length > int64(len(content)) {
+		return fmt.Errorf("range %d-%d out of bounds for %d-byte file", offset, length, len(content))
+	}
+
+	w.Header().Set("Content-Type", util.GetContentType(targetURL))
+	w.Header().Set("Accept-Ranges", "bytes")
+	s.headerManager.RewriteResponseHeaders(targetURL, w.Header())
+	s.handleRangeRequest(w, r, content, fmt.Sprintf("bytes=%d-%d", offset, offset+length-1))
+	return nil
+}
+
+// streamReencryptedSegment serves targetURL decrypted with the upstream key
+// at encodedUpstreamKeyURL and re-encrypted with this session's
+// proxy-generated key, so the client never needs the real upstream key.
+func (s *Server) streamReencryptedSegment(w http.ResponseWriter, targetURL, encodedUpstreamKeyURL, ivHex, sign, ts string) error {
+	upstreamKeyURL, err := url.QueryUnescape(encodedUpstreamKeyURL)
+	if err != nil {
+		return fmt.Errorf("invalid key reference: %w", err)
+	}
+
+	iv, err := hex.DecodeString(ivHex)
+	if err != nil || len(iv) != 16 {
+		return fmt.Errorf("invalid segment IV")
+	}
+
+	session, err := s.drm.SessionFor(sign+"|"+ts, upstreamKeyURL)
+	if err != nil {
+		return err
+	}
+
+	upstreamKey, found := s.keyCache.Get(upstreamKeyURL)
+	if !found {
+		var ttl time.Duration
+		upstreamKey, _, ttl, err = s.coalescedFetch(upstreamKeyURL)
+		if err != nil {
+			return fmt.Errorf("fetch upstream key: %w", err)
+		}
+		if ttl >= 0 {
+			s.keyCache.SetWithTTL(upstreamKeyURL, upstreamKey, ttl)
+		}
+	}
+
+	ciphertext, err := s.fetchBytesCapped(targetURL, s.config.DRM.MaxSegmentBytes)
+	if err != nil {
+		return err
+	}
+
+	plaintext, err := drm.Decrypt(ciphertext, upstreamKey, iv)
+	if err != nil {
+		return fmt.Errorf("decrypt segment: %w", err)
+	}
+
+	reencrypted, err := drm.Encrypt(plaintext, session.Key[:], iv)
+	if err != nil {
+		return fmt.Errorf("re-encrypt segment: %w", err)
+	}
+
+	w.Header().Set("Content-Type", util.GetContentType(targetURL))
+	w.Header().Set("Content-Length", strconv.Itoa(len(reencrypted)))
+	w.Header().Set("Accept-Ranges", "bytes")
+	s.headerManager.RewriteResponseHeaders(targetURL, w.Header())
+	_, err = w.Write(reencrypted)
+	return err
 }
 
 func (s *Server) streamContent(w http.ResponseWriter, r *http.Request, targetURL string, content []byte, contentType string) {